@@ -9,7 +9,11 @@ import (
 	"strings"
 	"time"
 
+	"github.com/seaptc/seaptc/conference"
+	"github.com/seaptc/seaptc/mail"
+	"github.com/seaptc/seaptc/notify"
 	"github.com/seaptc/seaptc/store"
+	"github.com/seaptc/seaptc/throttle"
 )
 
 type Service interface {
@@ -17,6 +21,17 @@ type Service interface {
 	Execute(fn interface{}, w http.ResponseWriter, r *http.Request)
 }
 
+// PermissionService is implemented by services that restrict handlers by
+// permission.
+type PermissionService interface {
+	// HandlerPermissions returns the permission required for each handler
+	// path. Every handler must have an entry. Use
+	// conference.PermissionPublic for handlers without restriction. The
+	// service checks the permission in Execute, see
+	// RequestContext.Permission.
+	HandlerPermissions() map[string]conference.Permission
+}
+
 type Debug struct {
 	LoginDate time.Time
 }
@@ -28,15 +43,25 @@ type Application struct {
 
 	TimeOverride time.Duration // for debugging
 
+	// Throttle tracks failed login attempts.
+	Throttle *throttle.Throttler
+
+	// mail overrides the sender in the conference configuration. See
+	// Mailer.
+	mail     mail.Sender
+	mailFrom string
+
 	templateFuncs template.FuncMap
 }
 
 func New(ctx context.Context, projectID string, useEmulator bool, assetsDir string, devMode bool, timeOverride time.Duration,
-	services []Service) (http.Handler, error) {
+	notifier notify.Notifier, storeAttempts bool, mailer mail.Sender, mailFrom string, services []Service) (http.Handler, error) {
 	app := &Application{
 		Protocol:     "https",
 		AssetsDir:    assetsDir,
 		TimeOverride: timeOverride,
+		mail:         mailer,
+		mailFrom:     mailFrom,
 	}
 	if devMode {
 		app.Protocol = "http"
@@ -47,6 +72,20 @@ func New(ctx context.Context, projectID string, useEmulator bool, assetsDir stri
 	if err != nil {
 		return nil, err
 	}
+	if notifier != nil {
+		app.Store.SetNotifier(notifier)
+		if err := app.Store.Subscribe(ctx); err != nil {
+			return nil, err
+		}
+	}
+
+	// Attempts are tracked in the store when requests are served by more
+	// than one instance.
+	if storeAttempts {
+		app.Throttle = throttle.New(app.Store)
+	} else {
+		app.Throttle = throttle.New(throttle.NewMemory())
+	}
 
 	app.initTemplateFuncMap(assetsDir)
 	mux := http.NewServeMux()
@@ -73,9 +112,45 @@ func New(ctx context.Context, projectID string, useEmulator bool, assetsDir stri
 
 var errorType = reflect.TypeOf((*error)(nil)).Elem()
 
+// MailConfigured returns true if Mailer returns a sender.
+func (app *Application) MailConfigured(conf *conference.Conference) bool {
+	return app.mail != nil || conf.Configuration.MailSender != ""
+}
+
+// Mailer returns the sender for email. The sender passed to New is used if
+// not nil. Otherwise the sender is opened from the MailSender field of the
+// conference configuration. Nil is returned if mail is not configured.
+func (app *Application) Mailer(conf *conference.Conference) (mail.Sender, error) {
+	if app.mail != nil {
+		return app.mail, nil
+	}
+	if conf.Configuration.MailSender == "" {
+		return nil, nil
+	}
+	return mail.Open(conf.Configuration.MailSender, app.mailFrom)
+}
+
+// Now returns the current time or the time set with TimeOverride for
+// rehearsals.
+func (app *Application) Now(conf *conference.Conference) time.Time {
+	if app.TimeOverride != 0 {
+		return conf.Date.Add(app.TimeOverride)
+	}
+	return time.Now()
+}
+
 func (app *Application) addHandlers(service Service, mux *http.ServeMux) error {
 	v := reflect.ValueOf(service)
 	t := v.Type()
+
+	var permissions map[string]conference.Permission
+	if ps, ok := service.(PermissionService); ok {
+		permissions = make(map[string]conference.Permission)
+		for path, p := range ps.HandlerPermissions() {
+			permissions[path] = p
+		}
+	}
+
 	for i := 0; i < t.NumMethod(); i++ {
 		m := t.Method(i)
 		if !strings.HasPrefix(m.Name, "Serve_") {
@@ -86,20 +161,38 @@ func (app *Application) addHandlers(service Service, mux *http.ServeMux) error {
 		}
 
 		path := strings.ReplaceAll(strings.TrimPrefix(m.Name, "Serve"), "_", "/")
-		mux.Handle(path, &handler{
+		h := &handler{
 			service: service,
 			fn:      m.Func.Interface(),
-		})
+		}
+		if permissions != nil {
+			p, ok := permissions[path]
+			if !ok {
+				return fmt.Errorf("application: %T does not declare permission for %s", service, path)
+			}
+			delete(permissions, path)
+			h.permission = p
+		}
+		mux.Handle(path, h)
 	}
 
+	for path := range permissions {
+		return fmt.Errorf("application: %T declares permission for unknown handler %s", service, path)
+	}
 	return nil
 }
 
 type handler struct {
-	service Service
-	fn      interface{}
+	service    Service
+	fn         interface{}
+	permission conference.Permission
 }
 
+type permissionKey struct{}
+
 func (h *handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if h.permission != conference.PermissionPublic {
+		r = r.WithContext(context.WithValue(r.Context(), permissionKey{}, h.permission))
+	}
 	h.service.Execute(h.fn, w, r)
 }
@@ -102,3 +102,26 @@ func split(s string) (string, string) {
 	}
 	return s, ""
 }
+
+// UnsubscribePurpose is the purpose of tokens in email unsubscribe links.
+const UnsubscribePurpose = "unsubscribe"
+
+// SignToken returns a signed token for a link in an email. The purpose is
+// included in the signed value so that a token for one purpose cannot be
+// used for another.
+func SignToken(secret string, maxAge int64, purpose string, value string) string {
+	return SignValue(secret, maxAge, EncodeStringsForCookie(purpose, value))
+}
+
+// VerifyToken returns the value signed by SignToken with purpose.
+func VerifyToken(secret string, purpose string, token string) (string, bool) {
+	s, ok := VerifySignature(secret, token)
+	if !ok {
+		return "", false
+	}
+	parts, err := DecodeStringsFromCookie(s)
+	if err != nil || len(parts) != 2 || parts[0] != purpose {
+		return "", false
+	}
+	return parts[1], true
+}
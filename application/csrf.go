@@ -0,0 +1,114 @@
+package application
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"html/template"
+	"mime"
+	"net/http"
+)
+
+// Forms that change state include a CSRF token. The token is the value of
+// the csrf cookie signed with Configuration.CookieKey. A request is accepted
+// when the token signature is valid and the signed value matches the cookie.
+// Another site can cause the browser to send the cookie, but it cannot read
+// the cookie to create a token.
+
+const (
+	csrfCookieName = "csrf"
+	csrfFieldName  = "_csrf"
+	csrfHeaderName = "X-CSRF-Token"
+
+	csrfCookieMaxAge = 30 * 24 * 3600
+	csrfTokenMaxAge  = 24 * 3600
+
+	// multipartMaxMemory is the default used by http.Request.FormFile.
+	multipartMaxMemory = 32 << 20
+)
+
+// ErrCSRF is returned by CheckCSRF when a request does not have a valid
+// token.
+var ErrCSRF = &HTTPError{
+	Status:  http.StatusForbidden,
+	Message: "The form has expired or was not submitted from this site. Go back, reload the page and try again.",
+}
+
+// csrfSecret returns the value of the csrf cookie. If the request does not
+// have the cookie, a new value is created and set in the response.
+func (rc *RequestContext) csrfSecret() (string, error) {
+	if rc.csrfSecretValue != "" {
+		return rc.csrfSecretValue, nil
+	}
+	if c, err := rc.Request.Cookie(csrfCookieName); err == nil && c.Value != "" {
+		rc.csrfSecretValue = c.Value
+		return c.Value, nil
+	}
+	var b [16]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		return "", err
+	}
+	rc.csrfSecretValue = hex.EncodeToString(b[:])
+	http.SetCookie(rc.Response, &http.Cookie{
+		Name:     csrfCookieName,
+		Value:    rc.csrfSecretValue,
+		MaxAge:   csrfCookieMaxAge,
+		Path:     "/",
+		HttpOnly: true,
+		Secure:   rc.secureCookies,
+		SameSite: http.SameSiteLaxMode,
+	})
+	return rc.csrfSecretValue, nil
+}
+
+// CSRFToken returns the token to include in forms that change state.
+func (rc *RequestContext) CSRFToken() (string, error) {
+	secret, err := rc.csrfSecret()
+	if err != nil {
+		return "", err
+	}
+	return SignValue(rc.Conference.Configuration.CookieKey, csrfTokenMaxAge, secret), nil
+}
+
+// CSRFField returns a hidden form input with the CSRF token. Use
+// {{$.CSRFField}} in every form with method POST.
+func (rc *RequestContext) CSRFField() (template.HTML, error) {
+	token, err := rc.CSRFToken()
+	if err != nil {
+		return "", err
+	}
+	return template.HTML(fmt.Sprintf(`<input type="hidden" name="%s" value="%s">`,
+		csrfFieldName, template.HTMLEscapeString(token))), nil
+}
+
+// CheckCSRF returns ErrCSRF if the request method can change state and the
+// request does not have a valid token in the form or the X-CSRF-Token
+// header. Services with handlers for forms call CheckCSRF from Execute.
+func (rc *RequestContext) CheckCSRF() error {
+	switch rc.Request.Method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions:
+		return nil
+	}
+	c, err := rc.Request.Cookie(csrfCookieName)
+	if err != nil || c.Value == "" {
+		return ErrCSRF
+	}
+	token := rc.Request.Header.Get(csrfHeaderName)
+	if token == "" {
+		// ParseForm in Init does not read multipart bodies. Parse the body
+		// here so that the token is available. Handlers get the parsed
+		// form and files from the request as usual.
+		if mt, _, _ := mime.ParseMediaType(rc.Request.Header.Get("Content-Type")); mt == "multipart/form-data" {
+			if err := rc.Request.ParseMultipartForm(multipartMaxMemory); err != nil {
+				return ErrCSRF
+			}
+		}
+		token = rc.Request.Form.Get(csrfFieldName)
+	}
+	value, ok := VerifySignature(rc.Conference.Configuration.CookieKey, token)
+	if !ok || !hmac.Equal([]byte(value), []byte(c.Value)) {
+		return ErrCSRF
+	}
+	return nil
+}
@@ -0,0 +1,85 @@
+package application
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/seaptc/seaptc/conference"
+)
+
+// Staff preview the participant site at another time with the sitePreview
+// cookie. The cookie is honored only for requests from logged in staff.
+
+const (
+	staffCookieName       = "staff"
+	sitePreviewCookieName = "sitePreview"
+	sitePreviewMaxAge     = 24 * 3600
+)
+
+// StaffCookieID returns the lower case ID from the staff login cookie or ""
+// if the request does not have a valid cookie. The caller checks that the ID
+// has a role.
+func (rc *RequestContext) StaffCookieID() string {
+	c, err := rc.Request.Cookie(staffCookieName)
+	if err != nil {
+		return ""
+	}
+	s, ok := VerifySignature(rc.Conference.Configuration.CookieKey, c.Value)
+	if !ok {
+		return ""
+	}
+	parts, err := DecodeStringsFromCookie(s)
+	if err != nil || len(parts) != 1 {
+		return ""
+	}
+	return strings.ToLower(parts[0])
+}
+
+// SetSitePreview sets the time for previewing the participant site. A zero
+// time ends the preview.
+func (rc *RequestContext) SetSitePreview(t time.Time) {
+	if t.IsZero() {
+		http.SetCookie(rc.Response, &http.Cookie{
+			Name:     sitePreviewCookieName,
+			Value:    "",
+			MaxAge:   -1,
+			Path:     "/",
+			HttpOnly: true,
+			Secure:   rc.secureCookies,
+			SameSite: http.SameSiteLaxMode,
+		})
+		return
+	}
+	http.SetCookie(rc.Response, &http.Cookie{
+		Name: sitePreviewCookieName,
+		Value: SignValue(
+			rc.Conference.Configuration.CookieKey,
+			sitePreviewMaxAge,
+			strconv.FormatInt(t.Unix(), 10)),
+		MaxAge:   sitePreviewMaxAge,
+		Path:     "/",
+		HttpOnly: true,
+		Secure:   rc.secureCookies,
+		SameSite: http.SameSiteLaxMode,
+	})
+}
+
+// SitePreview returns the participant site preview time set by staff. If the
+// request is not from staff or a preview is not set, ok is false.
+func (rc *RequestContext) SitePreview() (t time.Time, ok bool) {
+	c, err := rc.Request.Cookie(sitePreviewCookieName)
+	if err != nil {
+		return time.Time{}, false
+	}
+	s, ok := VerifySignature(rc.Conference.Configuration.CookieKey, c.Value)
+	if !ok {
+		return time.Time{}, false
+	}
+	sec, err := strconv.ParseInt(s, 10, 64)
+	if err != nil || !rc.Conference.IsStaff(rc.StaffCookieID()) {
+		return time.Time{}, false
+	}
+	return time.Unix(sec, 0).In(conference.TimeLocation), true
+}
@@ -6,6 +6,7 @@ import (
 	"errors"
 	"fmt"
 	"html/template"
+	"mime"
 	"net/http"
 	"strings"
 
@@ -19,14 +20,23 @@ type RequestContext struct {
 	Ctx      context.Context
 	invalid  map[string]bool
 
+	secureCookies   bool
+	csrfSecretValue string
+
 	Conference    *conference.Conference
 	ConfFromCache bool
+
+	// Permission is the permission required for the handler. See
+	// PermissionService.
+	Permission conference.Permission
 }
 
 func (rc *RequestContext) Init(app *Application, w http.ResponseWriter, r *http.Request) error {
 	rc.Request = r
 	rc.Response = w
 	rc.Ctx = log.ContextWithTraceID(r)
+	rc.secureCookies = app.Protocol == "https"
+	rc.Permission, _ = r.Context().Value(permissionKey{}).(conference.Permission)
 
 	var err error
 	rc.Conference, rc.ConfFromCache, err = app.Store.GetConference(rc.Ctx, false)
@@ -78,6 +88,18 @@ func (rc *RequestContext) Respond(t *template.Template, status int, v interface{
 	return nil
 }
 
+// RespondAttachment responds with a file for download. The browser is told
+// not to display the file so that uploaded HTML is not run in the site's
+// origin.
+func (rc *RequestContext) RespondAttachment(name string, contentType string, data []byte) {
+	h := rc.Response.Header()
+	h.Set("Content-Type", contentType)
+	h.Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": name}))
+	h.Set("X-Content-Type-Options", "nosniff")
+	h.Set("Cache-Control", "private, max-age=0")
+	rc.Response.Write(data)
+}
+
 func (rc *RequestContext) IsPost() bool {
 	return rc.Request.Method == "POST"
 }
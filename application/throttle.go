@@ -0,0 +1,42 @@
+package application
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"net"
+)
+
+// ClientIP returns the IP address of the client. On App Engine, the address
+// is set by the front end in the X-Appengine-User-IP header.
+func (rc *RequestContext) ClientIP() string {
+	if ip := rc.Request.Header.Get("X-Appengine-User-IP"); ip != "" {
+		return ip
+	}
+	host, _, err := net.SplitHostPort(rc.Request.RemoteAddr)
+	if err != nil {
+		return rc.Request.RemoteAddr
+	}
+	return host
+}
+
+// AttemptKeys returns the keys for tracking failed login attempts from the
+// client: the IP address and, if set, a hash of the csrf cookie.
+func (rc *RequestContext) AttemptKeys() []string {
+	keys := []string{"ip:" + rc.ClientIP()}
+	if c, err := rc.Request.Cookie(csrfCookieName); err == nil && c.Value != "" {
+		sum := sha256.Sum256([]byte(c.Value))
+		keys = append(keys, "cookie:"+hex.EncodeToString(sum[:6]))
+	}
+	return keys
+}
+
+// ScopedAttemptKeys returns AttemptKeys with scope appended to each key. Use
+// scoped keys for attempts that should not delay login, for example login
+// link requests.
+func (rc *RequestContext) ScopedAttemptKeys(scope string) []string {
+	keys := rc.AttemptKeys()
+	for i := range keys {
+		keys[i] += "/" + scope
+	}
+	return keys
+}
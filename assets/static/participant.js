@@ -0,0 +1,76 @@
+// Offline support for the participant site. See sw.js.
+
+(function() {
+  if (!('serviceWorker' in navigator)) {
+    return;
+  }
+
+  function post(msg) {
+    navigator.serviceWorker.ready.then(function(reg) {
+      if (reg.active) {
+        reg.active.postMessage(msg);
+      }
+    });
+  }
+
+  function showStatus(msg) {
+    var el = document.getElementById('offline-status');
+    if (!el) {
+      return;
+    }
+    var html = '';
+    if (msg.queued > 0) {
+      html += '<div class="alert alert-info">' + msg.queued +
+        (msg.queued === 1 ? ' evaluation is' : ' evaluations are') +
+        ' saved on this device and will be sent when you have a network connection.</div>';
+    }
+    if (msg.problems && msg.problems.length > 0) {
+      html += '<div class="alert alert-warning">Evaluations saved offline were not recorded because ' +
+        'they changed on another device or were entered for another participant: ' +
+        msg.problems.map(function(p) { return p.label; }).join(', ') +
+        '. Check your completed evaluations and evaluate these again. ' +
+        '<a href="#" id="offline-dismiss">Dismiss</a></div>';
+    }
+    el.innerHTML = html;
+    var dismiss = document.getElementById('offline-dismiss');
+    if (dismiss) {
+      dismiss.addEventListener('click', function(e) {
+        e.preventDefault();
+        post({type: 'clearProblems'});
+      });
+    }
+  }
+
+  navigator.serviceWorker.addEventListener('message', function(e) {
+    if (e.data && e.data.type === 'status') {
+      showStatus(e.data);
+    }
+  });
+
+  navigator.serviceWorker.register('/serviceWorker', {scope: '/'});
+
+  window.addEventListener('online', function() { post({type: 'replay'}); });
+
+  window.addEventListener('load', function() {
+    // The offline form does not have an evaluation code. Use the code from
+    // the URL of the page that the form replaces.
+    var input = document.getElementById('evalCode');
+    if (input && !input.value) {
+      var code = new URLSearchParams(location.search).get('evalCode');
+      if (code) {
+        input.value = code;
+      }
+    }
+
+    if (!navigator.onLine) {
+      post({type: 'replay'});
+      return;
+    }
+    var urls = ['/', '/eval?offline=1'];
+    document.querySelectorAll('a[data-offline]').forEach(function(a) {
+      urls.push(a.getAttribute('href'));
+    });
+    post({type: 'precache', urls: urls});
+    post({type: 'replay'});
+  });
+})();
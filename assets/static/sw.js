@@ -0,0 +1,296 @@
+// Service worker for the offline participant site.
+//
+// Static assets are served from the cache. The home page and evaluation
+// forms are fetched from the network when possible and from the cache when
+// the network is not available. Evaluations submitted while offline are
+// queued in IndexedDB and replayed to /eval when the network returns. The
+// server rejects a replayed evaluation with status 409 when the stored
+// evaluation changed after the form was rendered. Rejected evaluations are
+// kept so the participant can review them.
+
+const staticCache = 'ptc-static-v1';
+const pagesCache = 'ptc-pages-v1';
+
+const staticFiles = [
+  '/static/bootstrap.min.css',
+  '/static/favicon.ico',
+  '/static/manifest.json',
+  '/static/map.pdf',
+  '/static/map.png',
+  '/static/participant.js',
+  '/static/patch-color.png',
+];
+
+const pagePaths = ['/', '/eval', '/changeClass'];
+
+self.addEventListener('install', event => {
+  event.waitUntil(
+    caches.open(staticCache)
+      .then(cache => cache.addAll(staticFiles))
+      .then(() => self.skipWaiting()));
+});
+
+self.addEventListener('activate', event => {
+  event.waitUntil(
+    caches.keys()
+      .then(keys => Promise.all(keys
+        .filter(key => key !== staticCache && key !== pagesCache)
+        .map(key => caches.delete(key))))
+      .then(() => self.clients.claim())
+      .then(() => replay()));
+});
+
+self.addEventListener('fetch', event => {
+  const request = event.request;
+  const url = new URL(request.url);
+  if (url.origin !== self.location.origin) {
+    return;
+  }
+  if (url.pathname === '/logout') {
+    event.respondWith(caches.delete(pagesCache).then(() => fetch(request)));
+    return;
+  }
+  if (request.method === 'POST' && url.pathname === '/eval') {
+    event.respondWith(submitEvaluation(request));
+    return;
+  }
+  if (request.method !== 'GET') {
+    return;
+  }
+  if (url.pathname.startsWith('/static/')) {
+    event.respondWith(
+      caches.match(request, {ignoreSearch: true})
+        .then(response => response || fetch(request)));
+    return;
+  }
+  if (pagePaths.includes(url.pathname)) {
+    event.respondWith(fetchPage(request, url));
+  }
+});
+
+self.addEventListener('sync', event => {
+  if (event.tag === 'replay') {
+    event.waitUntil(replay());
+  }
+});
+
+self.addEventListener('message', event => {
+  const msg = event.data || {};
+  switch (msg.type) {
+  case 'replay':
+    event.waitUntil(replay());
+    break;
+  case 'precache':
+    event.waitUntil(precache(msg.urls || []));
+    break;
+  case 'clearProblems':
+    event.waitUntil(clearStore('evalProblems').then(notify));
+    break;
+  }
+});
+
+// fetchPage fetches a page from the network and updates the cache. The
+// cached page is used when the network is not available.
+async function fetchPage(request, url) {
+  try {
+    const response = await fetch(request);
+    if (response.ok && !response.redirected) {
+      const cache = await caches.open(pagesCache);
+      await cache.put(request, response.clone());
+    }
+    return response;
+  } catch (err) {
+    const cache = await caches.open(pagesCache);
+    let response = await cache.match(request);
+    if (!response && url.pathname === '/eval' && url.searchParams.get('evalCode')) {
+      // Use the generic form for classes not evaluated while online.
+      response = await cache.match('/eval?offline=1');
+    }
+    return response || offlinePage(
+      'You are offline',
+      'This page is not available offline. Try again when you have a network connection.');
+  }
+}
+
+async function precache(urls) {
+  const cache = await caches.open(pagesCache);
+  for (const u of urls) {
+    try {
+      const response = await fetch(u, {credentials: 'same-origin'});
+      if (response.ok && !response.redirected) {
+        await cache.put(u, response);
+      }
+    } catch (err) {
+      return;
+    }
+  }
+}
+
+// submitEvaluation posts an evaluation to the server. If the network is not
+// available, the evaluation is queued for replay.
+async function submitEvaluation(request) {
+  const body = await request.clone().text();
+  try {
+    return await fetch(request);
+  } catch (err) {
+    const params = new URLSearchParams(body);
+    await addToStore('evalQueue', {
+      url: request.url,
+      body: body,
+      time: Date.now(),
+      label: params.get('evalCode') || 'class',
+    });
+    if (self.registration.sync) {
+      try {
+        await self.registration.sync.register('replay');
+      } catch (err) {
+        // Replay when a page is loaded or the browser is online.
+      }
+    }
+    await notify();
+    return offlinePage(
+      'Evaluation saved',
+      'You are offline. Your evaluation is saved on this device and will be sent when you have a network connection.');
+  }
+}
+
+let replaying = null;
+
+// replay sends queued evaluations to the server in the order submitted.
+function replay() {
+  if (!replaying) {
+    replaying = replayQueue().finally(() => { replaying = null; });
+  }
+  return replaying;
+}
+
+async function replayQueue() {
+  const queue = await getStore('evalQueue');
+  if (queue.length === 0) {
+    return notify();
+  }
+  queue.sort((a, b) => a.time - b.time);
+  let token;
+  try {
+    token = await fetchCSRFToken();
+  } catch (err) {
+    return notify();
+  }
+  for (const item of queue) {
+    let response;
+    try {
+      response = await fetch(item.url, {
+        method: 'POST',
+        credentials: 'same-origin',
+        redirect: 'manual',
+        headers: {
+          'Content-Type': 'application/x-www-form-urlencoded',
+          'X-CSRF-Token': token,
+        },
+        body: item.body,
+      });
+    } catch (err) {
+      break;
+    }
+    if (response.status >= 500) {
+      break;
+    }
+    if (response.type !== 'opaqueredirect' && (response.status < 300 || response.status >= 400)) {
+      // Keep the response so the participant can review the conflict or
+      // other problem with the evaluation.
+      item.status = response.status;
+      item.response = await response.text();
+      await addToStore('evalProblems', item);
+    }
+    await deleteFromStore('evalQueue', item.id);
+  }
+  await caches.delete(pagesCache);
+  return notify();
+}
+
+// fetchCSRFToken returns a new token for replayed evaluations. The token in a
+// queued form may have expired.
+async function fetchCSRFToken() {
+  const response = await fetch('/eval?offline=1', {credentials: 'same-origin', redirect: 'manual'});
+  const m = (await response.text()).match(/name="_csrf" value="([^"]+)"/);
+  if (!m) {
+    throw new Error('token not found');
+  }
+  return m[1].replace(/&amp;/g, '&');
+}
+
+async function notify() {
+  const queued = await getStore('evalQueue');
+  const problems = await getStore('evalProblems');
+  const clients = await self.clients.matchAll({type: 'window'});
+  for (const client of clients) {
+    client.postMessage({
+      type: 'status',
+      queued: queued.length,
+      problems: problems.map(p => ({label: p.label, status: p.status, time: p.time})),
+    });
+  }
+}
+
+function offlinePage(title, message) {
+  const html = `<!DOCTYPE html>
+<html lang="en">
+<head>
+  <meta charset="utf-8">
+  <meta name="viewport" content="width=device-width, initial-scale=1, shrink-to-fit=no">
+  <link rel="stylesheet" href="/static/bootstrap.min.css">
+  <title>PTC</title>
+</head>
+<body>
+  <div class="mb-3" style="background-color: #82b5d4;">
+    <div class="container pb-3 pt-3"><h5 class="mb-0">Program & Training Conference</h5></div>
+  </div>
+  <div class="container">
+    <h5>${title}</h5>
+    <p>${message}
+    <p><a href="/" class="btn btn-secondary">Home</a>
+  </div>
+</body>
+</html>`;
+  return new Response(html, {headers: {'Content-Type': 'text/html; charset=utf-8'}});
+}
+
+// IndexedDB helpers.
+
+function openDB() {
+  return new Promise((resolve, reject) => {
+    const req = indexedDB.open('ptc', 1);
+    req.onupgradeneeded = () => {
+      req.result.createObjectStore('evalQueue', {keyPath: 'id', autoIncrement: true});
+      req.result.createObjectStore('evalProblems', {keyPath: 'id'});
+    };
+    req.onsuccess = () => resolve(req.result);
+    req.onerror = () => reject(req.error);
+  });
+}
+
+async function withStore(name, mode, fn) {
+  const db = await openDB();
+  return new Promise((resolve, reject) => {
+    const tx = db.transaction(name, mode);
+    const req = fn(tx.objectStore(name));
+    tx.oncomplete = () => resolve(req && req.result);
+    tx.onerror = () => reject(tx.error);
+  });
+}
+
+function getStore(name) {
+  return withStore(name, 'readonly', store => store.getAll());
+}
+
+function addToStore(name, value) {
+  return withStore(name, 'readwrite', store => store.put(value));
+}
+
+function deleteFromStore(name, key) {
+  return withStore(name, 'readwrite', store => store.delete(key));
+}
+
+function clearStore(name) {
+  return withStore(name, 'readwrite', store => store.clear());
+}
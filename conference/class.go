@@ -23,6 +23,10 @@ type Class struct {
 	InstructorNames  []string `json:"instructorNames"`
 	InstructorEmails []string `json:"instructorEmails"`
 	EvaluationCodes  []string `json:"evaluationCodes"`
+
+	// Series links the class to classes in other years. If not set, classes
+	// are linked by title.
+	Series string `json:"series,omitempty"`
 }
 
 // Length returns length of class in sessions.
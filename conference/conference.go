@@ -3,7 +3,6 @@ package conference
 import (
 	"log"
 	"strconv"
-	"strings"
 	"sync"
 	"time"
 )
@@ -48,9 +47,12 @@ type Conference struct {
 	classes                 []*Class
 	classesByNumber         map[int]*Class
 	participants            []*Participant
+	importedParticipants    []*Participant
+	participantOverrides    map[string]*ParticipantOverride
 	instructorClasses       map[string][]int
 	participantsByID        map[string]*Participant
 	participantsByLoginCode map[string]*Participant
+	participantRedirects    map[string]string
 
 	Configuration *Configuration
 	Date          time.Time
@@ -66,9 +68,9 @@ type Conference struct {
 	}
 
 	ids struct {
-		once  sync.Once
-		staff map[string]bool
-		admin map[string]bool
+		once        sync.Once
+		roles       map[string][]string
+		permissions map[string]map[Permission]bool
 	}
 
 	lunch struct {
@@ -91,8 +93,11 @@ func (conf *Conference) copy() *Conference {
 	newConf.classes = conf.classes
 	newConf.classesByNumber = conf.classesByNumber
 	newConf.participants = conf.participants
+	newConf.importedParticipants = conf.importedParticipants
+	newConf.participantOverrides = conf.participantOverrides
 	newConf.participantsByID = conf.participantsByID
 	newConf.participantsByLoginCode = conf.participantsByLoginCode
+	newConf.participantRedirects = conf.participantRedirects
 	newConf.instructorClasses = conf.instructorClasses
 	newConf.Configuration = conf.Configuration
 	newConf.Date = conf.Date
@@ -108,14 +113,39 @@ func (conf *Conference) UpdateConfiguration(config *Configuration) *Conference {
 
 func (conf *Conference) UpdateParticipants(participants []*Participant) *Conference {
 	newConf := conf.copy()
-	newConf.participants = participants
-	newConf.participantsByID = make(map[string]*Participant, len(participants))
-	newConf.participantsByLoginCode = make(map[string]*Participant, len(participants))
-	for _, p := range participants {
+	newConf.importedParticipants = participants
+	newConf.setParticipants()
+	return newConf
+}
+
+// setParticipants applies the staff overrides to the imported participants.
+// Overridden participants are copies; the imported participants are not
+// modified.
+func (conf *Conference) setParticipants() {
+	conf.participants = make([]*Participant, len(conf.importedParticipants))
+	conf.participantsByID = make(map[string]*Participant, len(conf.importedParticipants))
+	conf.participantsByLoginCode = make(map[string]*Participant, len(conf.importedParticipants))
+	for i, p := range conf.importedParticipants {
 		p.init()
-		newConf.participantsByID[p.ID] = p
-		newConf.participantsByLoginCode[p.LoginCode] = p
+		if o := conf.participantOverrides[p.ID]; !o.IsEmpty() {
+			q := *p
+			o.apply(&q)
+			q.imported = p
+			q.override = o
+			q.init()
+			p = &q
+		}
+		conf.participants[i] = p
+		conf.participantsByID[p.ID] = p
+		conf.participantsByLoginCode[p.LoginCode] = p
 	}
+}
+
+// UpdateParticipantRedirects sets the map from old participant ID to current
+// participant ID. Redirects are created when participants are merged.
+func (conf *Conference) UpdateParticipantRedirects(redirects map[string]string) *Conference {
+	newConf := conf.copy()
+	newConf.participantRedirects = redirects
 	return newConf
 }
 
@@ -172,46 +202,39 @@ func (conf *Conference) PrintSignature(p *Participant) string {
 		}
 		buf = strconv.AppendInt(buf, int64(n), 36)
 	}
+	// Overridden fields are printed on the form. Include the fields only
+	// when overridden so that signatures of other participants do not
+	// change.
+	if !p.Override().IsEmpty() {
+		for _, s := range []string{p.Nickname, p.LunchOption, p.UnitType, p.UnitNumber} {
+			buf = append(buf, '|')
+			buf = append(buf, s...)
+		}
+	}
 	return string(buf)
 }
 
+// Participant returns the participant with the given ID. Redirects from old
+// participant IDs are followed.
 func (conf *Conference) Participant(id string) *Participant {
-	return conf.participantsByID[id]
-}
-
-func (conf *Conference) ParticipantFromLoginCode(loginCode string) *Participant {
-	return conf.participantsByLoginCode[loginCode]
-}
-
-func (conf *Conference) setupIDs() {
-	conf.ids.once.Do(func() {
-		conf.ids.staff = make(map[string]bool)
-		conf.ids.admin = make(map[string]bool)
-		for _, id := range conf.Configuration.StaffIDs {
-			conf.ids.staff[strings.ToLower(id)] = true
+	if p := conf.participantsByID[id]; p != nil {
+		return p
+	}
+	for i := 0; i < 10; i++ {
+		next, ok := conf.participantRedirects[id]
+		if !ok {
+			return nil
 		}
-		for _, id := range conf.Configuration.AdminIDs {
-			id = strings.ToLower(id)
-			conf.ids.staff[id] = true
-			conf.ids.admin[id] = true
+		if p := conf.participantsByID[next]; p != nil {
+			return p
 		}
-	})
-}
-
-func (conf *Conference) IsStaff(id string) bool {
-	if id == "" {
-		return false
+		id = next
 	}
-	conf.setupIDs()
-	return conf.ids.staff[id]
+	return nil
 }
 
-func (conf *Conference) IsAdmin(id string) bool {
-	if id == "" {
-		return false
-	}
-	conf.setupIDs()
-	return conf.ids.admin[id]
+func (conf *Conference) ParticipantFromLoginCode(loginCode string) *Participant {
+	return conf.participantsByLoginCode[loginCode]
 }
 
 func (conf *Conference) ClassParticipants(c *Class) []*Participant {
@@ -291,7 +314,6 @@ func (conf *Conference) GeneralLunch() *Lunch {
 		return tbdLunch
 	}
 	return conf.Configuration.Lunches[0]
-	return nil
 }
 
 func (conf *Conference) resolveClassNumbers(classNumbers []int) []*Class {
@@ -63,8 +63,18 @@ type Configuration struct {
 	AdminIDs  []string `json:"adminIDs"`
 	CookieKey string   `json:"cookieKey"` // HMAC key for signed cookies
 
+	// Roles maps role name to staff IDs. See Roles for the role names.
+	Roles map[string][]string `json:"roles"`
+
+	// ParticipantSite configures when participant site features are open.
+	ParticipantSite ParticipantSite `json:"participantSite"`
+
 	// URL of Doubleknot Export page
 	DoubleknotExportPageURL string `json:"doubleknotExportPageURL"`
+
+	// Mail sender: log, dir:{path} or smtp://{user}:{password}@{host}:{port}.
+	// Login links and email campaigns are turned off when empty.
+	MailSender string `json:"mailSender"`
 }
 
 func newConfiguration() *Configuration {
@@ -80,5 +90,8 @@ func (config *Configuration) Validate() error {
 	if config.CookieKey == "" {
 		return errors.New("config: CookieKey not set")
 	}
-	return nil
+	if err := config.ParticipantSite.validate(); err != nil {
+		return err
+	}
+	return config.validateRoles()
 }
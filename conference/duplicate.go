@@ -0,0 +1,143 @@
+package conference
+
+import (
+	"sort"
+	"strings"
+	"unicode"
+)
+
+// Duplicate is a pair of participants from different registrations that are
+// likely the same person.
+type Duplicate struct {
+	A, B *Participant
+
+	// Reasons lists the matching fields: name, email, BSA number, phone.
+	Reasons []string
+}
+
+// normalizeLetters returns the lower case letters and digits in s.
+func normalizeLetters(s string) string {
+	var b strings.Builder
+	for _, r := range strings.ToLower(s) {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) {
+			b.WriteRune(r)
+		}
+	}
+	return b.String()
+}
+
+// normalizeDigits returns the digits in s with leading zeros removed.
+func normalizeDigits(s string) string {
+	var b strings.Builder
+	for _, r := range s {
+		if '0' <= r && r <= '9' {
+			b.WriteRune(r)
+		}
+	}
+	return strings.TrimLeft(b.String(), "0")
+}
+
+// normalizePhone returns the last ten digits of the phone number.
+func normalizePhone(s string) string {
+	s = normalizeDigits(s)
+	if len(s) > 10 {
+		s = s[len(s)-10:]
+	}
+	if len(s) < 7 {
+		return ""
+	}
+	return s
+}
+
+func duplicateKeys(p *Participant) map[string][]string {
+	keys := make(map[string][]string)
+	last := normalizeLetters(p.LastName)
+	if last != "" {
+		for _, first := range []string{p.FirstName, p.Nickname} {
+			if first := normalizeLetters(first); first != "" {
+				keys["name"] = append(keys["name"], first+"\n"+last)
+			}
+		}
+	}
+	if email := strings.ToLower(strings.TrimSpace(p.Email)); email != "" {
+		keys["email"] = []string{email}
+	}
+	if n := normalizeDigits(p.BSANumber); n != "" {
+		keys["BSA number"] = []string{n}
+	}
+	if phone := normalizePhone(p.Phone); phone != "" {
+		keys["phone"] = []string{phone}
+	}
+	return keys
+}
+
+// FindDuplicates returns pairs of participants in different registrations
+// that are likely the same person. A pair is reported when the normalized
+// name or BSA number matches. Matching email and phone are listed in the
+// reasons, but do not identify a duplicate on their own because family
+// members often share them.
+func FindDuplicates(participants []*Participant) []*Duplicate {
+	type pair struct{ a, b int }
+
+	index := make(map[string]map[string][]int)
+	for i, p := range participants {
+		for field, values := range duplicateKeys(p) {
+			m := index[field]
+			if m == nil {
+				m = make(map[string][]int)
+				index[field] = m
+			}
+			for _, v := range values {
+				m[v] = append(m[v], i)
+			}
+		}
+	}
+
+	reasons := make(map[pair]map[string]bool)
+	for field, m := range index {
+		for _, indices := range m {
+			for x := 0; x < len(indices); x++ {
+				for y := x + 1; y < len(indices); y++ {
+					a, b := indices[x], indices[y]
+					if a == b || participants[a].RegistrationNumber == participants[b].RegistrationNumber {
+						continue
+					}
+					if a > b {
+						a, b = b, a
+					}
+					k := pair{a, b}
+					if reasons[k] == nil {
+						reasons[k] = make(map[string]bool)
+					}
+					reasons[k][field] = true
+				}
+			}
+		}
+	}
+
+	var result []*Duplicate
+	for k, fields := range reasons {
+		if !fields["name"] && !fields["BSA number"] {
+			continue
+		}
+		d := &Duplicate{A: participants[k.a], B: participants[k.b]}
+		for field := range fields {
+			d.Reasons = append(d.Reasons, field)
+		}
+		sort.Strings(d.Reasons)
+		if DefaultParticipantLess(d.B, d.A) {
+			d.A, d.B = d.B, d.A
+		}
+		result = append(result, d)
+	}
+
+	sort.Slice(result, func(i, j int) bool {
+		switch {
+		case result[i].A.sortName != result[j].A.sortName:
+			return result[i].A.sortName < result[j].A.sortName
+		default:
+			return result[i].B.sortName < result[j].B.sortName
+		}
+	})
+	return result
+}
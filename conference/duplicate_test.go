@@ -0,0 +1,75 @@
+package conference
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestFindDuplicates(t *testing.T) {
+	for _, tt := range []struct {
+		name string
+		a, b Participant
+		want string // reasons, empty for no duplicate
+	}{
+		{
+			name: "name",
+			a:    Participant{FirstName: "Pat", LastName: "Lee"},
+			b:    Participant{FirstName: "pat", LastName: "Lee "},
+			want: "name",
+		},
+		{
+			name: "nickname",
+			a:    Participant{FirstName: "Patricia", Nickname: "Pat", LastName: "Lee"},
+			b:    Participant{FirstName: "Pat", LastName: "Lee"},
+			want: "name",
+		},
+		{
+			name: "BSA number",
+			a:    Participant{FirstName: "Pat", LastName: "Lee", BSANumber: "0012345"},
+			b:    Participant{FirstName: "Patrick", LastName: "Li", BSANumber: "12345"},
+			want: "BSA number",
+		},
+		{
+			name: "name and contact",
+			a:    Participant{FirstName: "Pat", LastName: "Lee", Email: "lee@example.com", Phone: "(206) 555-1212"},
+			b:    Participant{FirstName: "Pat", LastName: "Lee", Email: "LEE@example.com", Phone: "1-206-555-1212"},
+			want: "email,name,phone",
+		},
+		{
+			name: "family email and phone",
+			a:    Participant{FirstName: "Pat", LastName: "Lee", Email: "lee@example.com", Phone: "206-555-1212"},
+			b:    Participant{FirstName: "Sam", LastName: "Lee", Email: "lee@example.com", Phone: "206-555-1212"},
+		},
+		{
+			name: "same registration",
+			a:    Participant{FirstName: "Pat", LastName: "Lee", RegistrationNumber: "r1"},
+			b:    Participant{FirstName: "Pat", LastName: "Lee", RegistrationNumber: "r1"},
+		},
+		{
+			name: "different",
+			a:    Participant{FirstName: "Pat", LastName: "Lee"},
+			b:    Participant{FirstName: "Sam", LastName: "Wu"},
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			a, b := tt.a, tt.b
+			a.ID, b.ID = "a", "b"
+			if a.RegistrationNumber == "" {
+				a.RegistrationNumber, b.RegistrationNumber = "r1", "r2"
+			}
+			conf := New().UpdateParticipants([]*Participant{&a, &b})
+			duplicates := FindDuplicates(conf.Participants())
+			var got string
+			switch len(duplicates) {
+			case 0:
+			case 1:
+				got = strings.Join(duplicates[0].Reasons, ",")
+			default:
+				t.Fatalf("found %d duplicates, want at most 1", len(duplicates))
+			}
+			if got != tt.want {
+				t.Errorf("reasons = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
@@ -19,7 +19,7 @@ type SessionEvaluation struct {
 	Session            int       `json:"session"`
 	ClassNumber        int       `json:"class"`
 	KnowledgeRating    int       `json:"knowledge"`
-	PresentationRating int       `json:"promotion"`
+	PresentationRating int       `json:"presentation"`
 	UsefulnessRating   int       `json:"usefulness"`
 	OverallRating      int       `json:"overall"`
 	Comments           string    `json:"comments"`
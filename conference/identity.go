@@ -0,0 +1,134 @@
+package conference
+
+import "strings"
+
+// MatchRenamedParticipants matches participants that disappeared from a
+// registration import to new participants in the same registration. Because
+// the participant ID is a hash of the name fields, a name correction in
+// Doubleknot changes the participant's ID.
+//
+// Participants are matched by registration number and by fuzzy name or email.
+// The function returns a map from previous ID to current ID. Ambiguous
+// matches are not returned.
+func MatchRenamedParticipants(previous, current []*Participant) map[string]string {
+	currentIDs := make(map[string]bool, len(current))
+	for _, p := range current {
+		currentIDs[p.ID] = true
+	}
+	previousIDs := make(map[string]bool, len(previous))
+	for _, p := range previous {
+		previousIDs[p.ID] = true
+	}
+
+	// Group the candidates by registration number.
+	removed := make(map[string][]*Participant)
+	for _, p := range previous {
+		if !currentIDs[p.ID] {
+			removed[p.RegistrationNumber] = append(removed[p.RegistrationNumber], p)
+		}
+	}
+	added := make(map[string][]*Participant)
+	for _, p := range current {
+		if !previousIDs[p.ID] {
+			added[p.RegistrationNumber] = append(added[p.RegistrationNumber], p)
+		}
+	}
+
+	result := make(map[string]string)
+	for regNum, olds := range removed {
+		news := added[regNum]
+		if regNum == "" || len(news) == 0 {
+			continue
+		}
+
+		// Find the best match for each previous participant. Skip the
+		// participant if the best match is a tie or if the new participant
+		// is the best match for more than one previous participant.
+		best := make(map[*Participant]*Participant)
+		claims := make(map[*Participant]int)
+		for _, o := range olds {
+			var match *Participant
+			bestScore, tie := 0, false
+			for _, n := range news {
+				score := identityScore(o, n)
+				switch {
+				case score > bestScore:
+					match, bestScore, tie = n, score, false
+				case score == bestScore && score > 0:
+					tie = true
+				}
+			}
+			if match != nil && !tie {
+				best[o] = match
+				claims[match]++
+			}
+		}
+		for o, n := range best {
+			if claims[n] == 1 {
+				result[o.ID] = n.ID
+			}
+		}
+	}
+	return result
+}
+
+// identityScore returns a score for the likelihood that a and b are the same
+// person. A score less than two is not a match.
+func identityScore(a, b *Participant) int {
+	if a.Youth != b.Youth {
+		return 0
+	}
+
+	score := 0
+	if email := strings.ToLower(a.Email); email != "" && email == strings.ToLower(b.Email) {
+		score += 2
+	}
+	if nameDistance(a.FirstName, b.FirstName) <= 2 || nameDistance(a.NicknameOrFirstName(), b.NicknameOrFirstName()) == 0 {
+		score++
+	}
+	if nameDistance(a.LastName, b.LastName) <= 2 {
+		score++
+	}
+	if score < 2 {
+		return 0
+	}
+	return score
+}
+
+// nameDistance returns the edit distance between the normalized names.
+func nameDistance(a, b string) int {
+	s := []rune(normalizeLetters(a))
+	t := []rune(normalizeLetters(b))
+	if len(s) == 0 || len(t) == 0 {
+		// Missing names never match.
+		return len(s) + len(t) + 3
+	}
+
+	prev := make([]int, len(t)+1)
+	cur := make([]int, len(t)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(s); i++ {
+		cur[0] = i
+		for j := 1; j <= len(t); j++ {
+			cost := 1
+			if s[i-1] == t[j-1] {
+				cost = 0
+			}
+			cur[j] = min3(prev[j]+1, cur[j-1]+1, prev[j-1]+cost)
+		}
+		prev, cur = cur, prev
+	}
+	return prev[len(t)]
+}
+
+func min3(a, b, c int) int {
+	if b < a {
+		a = b
+	}
+	if c < a {
+		a = c
+	}
+	return a
+}
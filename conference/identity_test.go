@@ -0,0 +1,157 @@
+package conference
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestNameDistance(t *testing.T) {
+	for _, tt := range []struct {
+		a, b string
+		want int
+	}{
+		{"Lee", "lee", 0},
+		{"O'Brien", "OBrien", 0},
+		{"Jon", "John", 1},
+		{"Katherine", "Catherine", 1},
+		{"Smith", "Smyth", 1},
+		{"kitten", "sitting", 3},
+		{"Lee", "Leigh", 3},
+		{"Ann", "Anne-Marie", 6},
+		{"", "", 3},
+		{"", "Lee", 6},
+	} {
+		if got := nameDistance(tt.a, tt.b); got != tt.want {
+			t.Errorf("nameDistance(%q, %q) = %d, want %d", tt.a, tt.b, got, tt.want)
+		}
+	}
+}
+
+func TestIdentityScore(t *testing.T) {
+	for _, tt := range []struct {
+		name string
+		a, b Participant
+		want int
+	}{
+		{
+			name: "first name typo",
+			a:    Participant{FirstName: "Jon", LastName: "Lee"},
+			b:    Participant{FirstName: "John", LastName: "Lee"},
+			want: 2,
+		},
+		{
+			name: "nickname",
+			a:    Participant{FirstName: "Robert", Nickname: "Bob", LastName: "Lee"},
+			b:    Participant{FirstName: "Bob", LastName: "Lee"},
+			want: 2,
+		},
+		{
+			name: "last name changed",
+			a:    Participant{FirstName: "Pat", LastName: "Lee"},
+			b:    Participant{FirstName: "Pat", LastName: "Leigh"},
+			want: 0,
+		},
+		{
+			name: "last name changed, same email",
+			a:    Participant{FirstName: "Pat", LastName: "Lee", Email: "pat@example.com"},
+			b:    Participant{FirstName: "Pat", LastName: "Leigh", Email: "PAT@example.com"},
+			want: 3,
+		},
+		{
+			name: "same email only",
+			a:    Participant{FirstName: "Pat", LastName: "Lee", Email: "pat@example.com"},
+			b:    Participant{FirstName: "Alex", LastName: "Nguyen", Email: "pat@example.com"},
+			want: 2,
+		},
+		{
+			name: "all",
+			a:    Participant{FirstName: "Pat", LastName: "Lee", Email: "pat@example.com"},
+			b:    Participant{FirstName: "Pat", LastName: "Lee", Email: "pat@example.com"},
+			want: 4,
+		},
+		{
+			name: "missing first names",
+			a:    Participant{LastName: "Lee"},
+			b:    Participant{LastName: "Lee"},
+			want: 0,
+		},
+		{
+			name: "youth and adult",
+			a:    Participant{FirstName: "Pat", LastName: "Lee", Email: "pat@example.com", Youth: true},
+			b:    Participant{FirstName: "Pat", LastName: "Lee", Email: "pat@example.com"},
+			want: 0,
+		},
+		{
+			name: "different",
+			a:    Participant{FirstName: "Pat", LastName: "Lee"},
+			b:    Participant{FirstName: "Alex", LastName: "Nguyen"},
+			want: 0,
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := identityScore(&tt.a, &tt.b); got != tt.want {
+				t.Errorf("identityScore = %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMatchRenamedParticipants(t *testing.T) {
+	for _, tt := range []struct {
+		name              string
+		previous, current []*Participant
+		want              map[string]string
+	}{
+		{
+			name: "renamed",
+			previous: []*Participant{
+				{ID: "jon", FirstName: "Jon", LastName: "Lee", RegistrationNumber: "r1"},
+				{ID: "sam", FirstName: "Sam", LastName: "Lee", RegistrationNumber: "r1"},
+			},
+			current: []*Participant{
+				{ID: "john", FirstName: "John", LastName: "Lee", RegistrationNumber: "r1"},
+				{ID: "sam", FirstName: "Sam", LastName: "Lee", RegistrationNumber: "r1"},
+			},
+			want: map[string]string{"jon": "john"},
+		},
+		{
+			name: "other registration",
+			previous: []*Participant{
+				{ID: "jon", FirstName: "Jon", LastName: "Lee", RegistrationNumber: "r1"},
+			},
+			current: []*Participant{
+				{ID: "john", FirstName: "John", LastName: "Lee", RegistrationNumber: "r2"},
+			},
+			want: map[string]string{},
+		},
+		{
+			name: "tie",
+			previous: []*Participant{
+				{ID: "lee", FirstName: "Pat", LastName: "Lee", RegistrationNumber: "r1"},
+			},
+			current: []*Participant{
+				{ID: "lea", FirstName: "Pat", LastName: "Lea", RegistrationNumber: "r1"},
+				{ID: "lei", FirstName: "Pat", LastName: "Lei", RegistrationNumber: "r1"},
+			},
+			want: map[string]string{},
+		},
+		{
+			name: "claimed twice",
+			previous: []*Participant{
+				{ID: "jon", FirstName: "Jon", LastName: "Lee", RegistrationNumber: "r1"},
+				{ID: "johnn", FirstName: "Johnn", LastName: "Lee", RegistrationNumber: "r1"},
+			},
+			current: []*Participant{
+				{ID: "john", FirstName: "John", LastName: "Lee", RegistrationNumber: "r1"},
+			},
+			want: map[string]string{},
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			got := MatchRenamedParticipants(tt.previous, tt.current)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("MatchRenamedParticipants = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
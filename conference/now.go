@@ -0,0 +1,110 @@
+package conference
+
+import (
+	"fmt"
+	"sort"
+	"time"
+)
+
+// Activity is an activity in a location.
+type Activity struct {
+	*ScheduleTime
+	Name string
+
+	// SessionClass is the class for the activity or nil for lunch.
+	SessionClass *SessionClass
+}
+
+// SessionClassTime returns the time of the class in the session. Classes in
+// the lunch session are shorter and start or end at the class lunch seating.
+func (conf *Conference) SessionClassTime(sc *SessionClass) *ScheduleTime {
+	if sc.Session != LunchSession {
+		return SessionTimes[sc.Session]
+	}
+	if conf.ClassLunch(sc.Class).Seating == 1 {
+		return Seating1ClassTime
+	}
+	return Seating2ClassTime
+}
+
+// LocationActivities returns the activities in each location sorted by start
+// time. The general lunch location is not included.
+func (conf *Conference) LocationActivities() map[string][]*Activity {
+	locations := make(map[string][]*Activity)
+
+	ignoreLunchLocation := conf.GeneralLunch().Location
+
+	for _, l := range conf.Configuration.Lunches {
+		if l.Location == ignoreLunchLocation {
+			continue
+		}
+		t := Seating1LunchTime
+		if l.Seating != 1 {
+			t = Seating2LunchTime
+		}
+		locations[l.Location] = append(locations[l.Location],
+			&Activity{ScheduleTime: t, Name: fmt.Sprintf("%s Lunch", l.Name)})
+	}
+
+	for _, sessionClasses := range conf.Sessions() {
+		for _, sc := range sessionClasses {
+			locations[sc.Location] = append(locations[sc.Location],
+				&Activity{
+					ScheduleTime: conf.SessionClassTime(sc),
+					Name:         fmt.Sprintf("%d: %s%s", sc.Number, sc.ShortTitle(), sc.IofN()),
+					SessionClass: sc,
+				})
+		}
+	}
+
+	for _, activities := range locations {
+		sort.Slice(activities, func(i, j int) bool {
+			return activities[i].Start < activities[j].Start
+		})
+	}
+	return locations
+}
+
+// nowNext returns the index of the item in progress at time t and the index
+// of the next item to start after t. The index is -1 if there is no such
+// item. The items must be sorted by start time.
+func (conf *Conference) nowNext(n int, item func(i int) *ScheduleTime, t time.Time) (now, next int) {
+	now, next = -1, -1
+	d := t.Sub(conf.Date)
+	for i := 0; i < n; i++ {
+		st := item(i)
+		switch {
+		case st.Start <= d && d < st.End:
+			now = i
+		case st.Start > d && next < 0:
+			next = i
+		}
+	}
+	return now, next
+}
+
+// ScheduleNowNext returns the schedule items in progress and next at time t.
+// Nil is returned for items that do not exist.
+func (conf *Conference) ScheduleNowNext(schedule []*ScheduleItem, t time.Time) (now, next *ScheduleItem) {
+	i, j := conf.nowNext(len(schedule), func(i int) *ScheduleTime { return schedule[i].ScheduleTime }, t)
+	if i >= 0 {
+		now = schedule[i]
+	}
+	if j >= 0 {
+		next = schedule[j]
+	}
+	return now, next
+}
+
+// ActivitiesNowNext returns the activities in progress and next at time t.
+// Nil is returned for activities that do not exist.
+func (conf *Conference) ActivitiesNowNext(activities []*Activity, t time.Time) (now, next *Activity) {
+	i, j := conf.nowNext(len(activities), func(i int) *ScheduleTime { return activities[i].ScheduleTime }, t)
+	if i >= 0 {
+		now = activities[i]
+	}
+	if j >= 0 {
+		next = activities[j]
+	}
+	return now, next
+}
@@ -0,0 +1,81 @@
+package conference
+
+import "sort"
+
+// ParticipantOverride holds staff edits to a participant. Overrides are kept
+// separate from the imported registration data so that they persist across
+// imports. A nil field does not override the imported value.
+type ParticipantOverride struct {
+	Nickname    *string `json:"nickname,omitempty"`
+	LunchOption *string `json:"lunchOption,omitempty"`
+	UnitType    *string `json:"unitType,omitempty"`
+	UnitNumber  *string `json:"unitNumber,omitempty"`
+
+	// Classes replaces the imported classes when not nil. Participants
+	// change classes on the conference day with the participant site.
+	Classes []int `json:"classes,omitempty"`
+}
+
+// IsEmpty returns true if the override does not override any field.
+func (o *ParticipantOverride) IsEmpty() bool {
+	return o == nil || (o.Nickname == nil && o.LunchOption == nil && o.UnitType == nil && o.UnitNumber == nil && o.Classes == nil)
+}
+
+func (o *ParticipantOverride) apply(p *Participant) {
+	for _, f := range []struct {
+		override *string
+		value    *string
+	}{
+		{o.Nickname, &p.Nickname},
+		{o.LunchOption, &p.LunchOption},
+		{o.UnitType, &p.UnitType},
+		{o.UnitNumber, &p.UnitNumber},
+	} {
+		if f.override != nil {
+			*f.value = *f.override
+		}
+	}
+	if o.Classes != nil {
+		p.Classes = append([]int(nil), o.Classes...)
+	}
+}
+
+// Imported returns the participant as imported from registration, before
+// staff overrides are applied.
+func (p *Participant) Imported() *Participant {
+	if p.imported != nil {
+		return p.imported
+	}
+	return p
+}
+
+// Override returns the staff override for the participant or nil if the
+// participant does not have an override.
+func (p *Participant) Override() *ParticipantOverride {
+	return p.override
+}
+
+// UpdateParticipantOverrides sets the staff overrides, keyed by participant
+// ID.
+func (conf *Conference) UpdateParticipantOverrides(overrides map[string]*ParticipantOverride) *Conference {
+	newConf := conf.copy()
+	newConf.participantOverrides = overrides
+	newConf.setParticipants()
+	return newConf
+}
+
+// LunchOptions returns the sorted lunch options found in the imported
+// registrations. No lunch option, the empty string, is not included.
+func (conf *Conference) LunchOptions() []string {
+	seen := make(map[string]bool)
+	var options []string
+	for _, p := range conf.participants {
+		option := p.Imported().LunchOption
+		if option != "" && !seen[option] {
+			seen[option] = true
+			options = append(options, option)
+		}
+	}
+	sort.Strings(options)
+	return options
+}
@@ -51,6 +51,10 @@ type Participant struct {
 
 	LoginCode string `json:"loginCode"`
 	sortName  string
+
+	// imported and override are set when a staff override is applied.
+	imported *Participant
+	override *ParticipantOverride
 }
 
 // ParticipantID returns a hash of unique participant fields.
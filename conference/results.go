@@ -0,0 +1,122 @@
+package conference
+
+import (
+	"sort"
+	"strings"
+)
+
+// RatingCounts is the number of evaluations with each rating value. Index 0
+// is the number of evaluations without the rating.
+type RatingCounts [MaxEvalRating + 1]int
+
+func (r *RatingCounts) add(v int) {
+	if 0 <= v && v <= MaxEvalRating {
+		r[v]++
+	}
+}
+
+// Count returns the number of evaluations with the rating.
+func (r RatingCounts) Count() int {
+	n := 0
+	for v := 1; v <= MaxEvalRating; v++ {
+		n += r[v]
+	}
+	return n
+}
+
+// Percent returns the percentage of the ratings with value v.
+func (r RatingCounts) Percent(v int) int {
+	n := r.Count()
+	if n == 0 || v < 1 || v > MaxEvalRating {
+		return 0
+	}
+	return (100*r[v] + n/2) / n
+}
+
+// Average returns the average rating or zero if there are no ratings.
+func (r RatingCounts) Average() float64 {
+	n, sum := 0, 0
+	for v := 1; v <= MaxEvalRating; v++ {
+		n += r[v]
+		sum += v * r[v]
+	}
+	if n == 0 {
+		return 0
+	}
+	return float64(sum) / float64(n)
+}
+
+// MinResultEvaluations is the minimum number of evaluations for a session
+// before rating distributions and comments are shown. Fewer evaluations can
+// identify the participants to an instructor with the class roster.
+const MinResultEvaluations = 3
+
+// SessionResults is the evaluation results for a class in a session.
+type SessionResults struct {
+	*SessionClass
+	Evaluations int
+
+	// Suppressed is set when there are fewer than MinResultEvaluations
+	// evaluations. The ratings and comments are cleared.
+	Suppressed bool
+
+	Knowledge    RatingCounts
+	Presentation RatingCounts
+	Usefulness   RatingCounts
+	Overall      RatingCounts
+
+	// Comments are sorted so that the order does not identify the
+	// participant.
+	Comments []string
+}
+
+// isInstructor returns true if the participant is an instructor for the class
+// in the session.
+func (conf *Conference) isInstructor(participantID string, session int, classNumber int) bool {
+	classNumbers := conf.instructorClasses[participantID]
+	return session < len(classNumbers) && classNumbers[session] == classNumber
+}
+
+// ClassEvaluationResults returns the results for each session of the class
+// from the evaluations keyed by participant ID. Evaluations from the
+// instructors of the class are excluded. Ratings and comments are suppressed
+// for sessions with fewer than MinResultEvaluations evaluations.
+func (conf *Conference) ClassEvaluationResults(c *Class, evals map[string]*Evaluation) []*SessionResults {
+	results := conf.classEvaluationResults(c, evals)
+	for _, r := range results {
+		if r.Evaluations < MinResultEvaluations {
+			*r = SessionResults{SessionClass: r.SessionClass, Evaluations: r.Evaluations, Suppressed: true}
+		}
+	}
+	return results
+}
+
+func (conf *Conference) classEvaluationResults(c *Class, evals map[string]*Evaluation) []*SessionResults {
+	results := make([]*SessionResults, c.Length())
+	for i := range results {
+		results[i] = &SessionResults{SessionClass: &SessionClass{Class: c, Session: c.Start + i}}
+	}
+	for id, eval := range evals {
+		for _, se := range eval.Sessions {
+			if se.ClassNumber != c.Number || se.Session < c.Start || se.Session > c.End {
+				continue
+			}
+			if conf.isInstructor(id, se.Session, c.Number) {
+				continue
+			}
+			r := results[se.Session-c.Start]
+			r.Evaluations++
+			r.Knowledge.add(se.KnowledgeRating)
+			r.Presentation.add(se.PresentationRating)
+			r.Usefulness.add(se.UsefulnessRating)
+			r.Overall.add(se.OverallRating)
+			if comment := strings.TrimSpace(se.Comments); comment != "" {
+				r.Comments = append(r.Comments, comment)
+			}
+		}
+	}
+	for _, r := range results {
+		sort.Strings(r.Comments)
+	}
+	return results
+}
@@ -0,0 +1,136 @@
+package conference
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// Permission is the right to use a part of the dashboard.
+type Permission string
+
+const (
+	// PermissionPublic is the permission for handlers that do not require
+	// a login.
+	PermissionPublic Permission = ""
+
+	PermissionView             Permission = "view"             // participants, staff class details
+	PermissionEditParticipants Permission = "editParticipants" // participant overrides
+	PermissionRegistration     Permission = "registration"     // import, merge, instructor classes, contact details
+	PermissionClasses          Permission = "classes"          // refresh and upload classes
+	PermissionPrint            Permission = "print"            // participant forms
+	PermissionEvaluations      Permission = "evaluations"      // evaluations and reports
+	PermissionLunch            Permission = "lunch"            // lunch lists and stickers
+	PermissionEmail            Permission = "email"            // email campaigns
+	PermissionAdmin            Permission = "admin"            // configuration, audit log and history
+)
+
+// Roles maps role name to the permissions granted by the role. Roles are
+// assigned to staff IDs in Configuration.Roles. Configuration.StaffIDs have
+// the staff role and Configuration.AdminIDs have the admin role.
+var Roles = map[string][]Permission{
+	"admin": {
+		PermissionView, PermissionEditParticipants, PermissionRegistration, PermissionClasses,
+		PermissionPrint, PermissionEvaluations, PermissionLunch, PermissionEmail, PermissionAdmin,
+	},
+	"staff": {
+		PermissionView, PermissionEditParticipants, PermissionClasses, PermissionPrint, PermissionEvaluations,
+	},
+	"registrar":        {PermissionView, PermissionEditParticipants, PermissionRegistration, PermissionEmail},
+	"printer":          {PermissionView, PermissionPrint},
+	"evaluator":        {PermissionView, PermissionEvaluations},
+	"lunchCoordinator": {PermissionView, PermissionLunch},
+	"viewer":           {PermissionView},
+}
+
+// RoleNames returns the sorted role names.
+func RoleNames() []string {
+	var names []string
+	for name := range Roles {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func (config *Configuration) validateRoles() error {
+	for role := range config.Roles {
+		if _, ok := Roles[role]; !ok {
+			return fmt.Errorf("config: unknown role %q, roles are %s", role, strings.Join(RoleNames(), ", "))
+		}
+	}
+	return nil
+}
+
+// roleIDs returns the staff IDs for each role, including the IDs from
+// StaffIDs and AdminIDs.
+func (config *Configuration) roleIDs() map[string][]string {
+	result := map[string][]string{
+		"staff": config.StaffIDs,
+		"admin": config.AdminIDs,
+	}
+	for role, ids := range config.Roles {
+		result[role] = append(append([]string(nil), result[role]...), ids...)
+	}
+	return result
+}
+
+func (conf *Conference) setupIDs() {
+	conf.ids.once.Do(func() {
+		conf.ids.roles = make(map[string][]string)
+		conf.ids.permissions = make(map[string]map[Permission]bool)
+		for role, ids := range conf.Configuration.roleIDs() {
+			for _, id := range ids {
+				id = strings.ToLower(id)
+				conf.ids.roles[id] = append(conf.ids.roles[id], role)
+				m := conf.ids.permissions[id]
+				if m == nil {
+					m = make(map[Permission]bool)
+					conf.ids.permissions[id] = m
+				}
+				for _, p := range Roles[role] {
+					m[p] = true
+				}
+			}
+		}
+		for _, roles := range conf.ids.roles {
+			sort.Strings(roles)
+		}
+	})
+}
+
+// IsStaff returns true if id has a role.
+func (conf *Conference) IsStaff(id string) bool {
+	if id == "" {
+		return false
+	}
+	conf.setupIDs()
+	return len(conf.ids.roles[id]) > 0
+}
+
+// IsAdmin returns true if id has the admin permission.
+func (conf *Conference) IsAdmin(id string) bool {
+	return conf.HasPermission(id, PermissionAdmin)
+}
+
+// HasPermission returns true if a role assigned to id grants the permission.
+// All IDs have PermissionPublic.
+func (conf *Conference) HasPermission(id string, p Permission) bool {
+	if p == PermissionPublic {
+		return true
+	}
+	if id == "" {
+		return false
+	}
+	conf.setupIDs()
+	return conf.ids.permissions[id][p]
+}
+
+// StaffRoles returns the sorted names of the roles assigned to id.
+func (conf *Conference) StaffRoles(id string) []string {
+	if id == "" {
+		return nil
+	}
+	conf.setupIDs()
+	return conf.ids.roles[id]
+}
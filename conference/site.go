@@ -0,0 +1,121 @@
+package conference
+
+import (
+	"fmt"
+	"time"
+)
+
+// SiteWindow is the period when a feature of the participant site is open.
+// A nil time uses the default for the feature.
+type SiteWindow struct {
+	Open  *time.Time `json:"open,omitempty"`
+	Close *time.Time `json:"close,omitempty"`
+}
+
+// ParticipantSite configures when features of the participant site are open.
+// Times are in RFC 3339 format, for example "2020-10-17T07:00:00-07:00". Set
+// the open time to open a feature early. Set the close time to extend a
+// feature.
+type ParticipantSite struct {
+	// Login with the login code. Default: the conference day.
+	Login SiteWindow `json:"login"`
+
+	// Login with an emailed link and entering evaluations. Default: the
+	// conference day and the following day.
+	Evaluation SiteWindow `json:"evaluation"`
+
+	// Viewing the schedule. Default: the conference day and the following
+	// day.
+	Schedule SiteWindow `json:"schedule"`
+}
+
+// SiteTimes is a SiteWindow with the defaults applied.
+type SiteTimes struct {
+	Open, Close time.Time
+}
+
+func (st SiteTimes) contains(t time.Time) bool {
+	return !t.Before(st.Open) && t.Before(st.Close)
+}
+
+func (w *SiteWindow) times(defaultOpen, defaultClose time.Time) SiteTimes {
+	st := SiteTimes{Open: defaultOpen, Close: defaultClose}
+	if w.Open != nil {
+		st.Open = *w.Open
+	}
+	if w.Close != nil {
+		st.Close = *w.Close
+	}
+	return st
+}
+
+func (w *SiteWindow) validate(name string) error {
+	if w.Open != nil && w.Close != nil && !w.Open.Before(*w.Close) {
+		return fmt.Errorf("config: participant site %s opens after it closes", name)
+	}
+	return nil
+}
+
+func (site *ParticipantSite) validate() error {
+	if err := site.Login.validate("login"); err != nil {
+		return err
+	}
+	if err := site.Evaluation.validate("evaluation"); err != nil {
+		return err
+	}
+	return site.Schedule.validate("schedule")
+}
+
+// SiteSchedule is the open and close times of the participant site features.
+type SiteSchedule struct {
+	Login, Evaluation, Schedule SiteTimes
+}
+
+// ParticipantSiteSchedule returns the times when participant site features
+// are open.
+func (conf *Conference) ParticipantSiteSchedule() *SiteSchedule {
+	site := &conf.Configuration.ParticipantSite
+	day := conf.Date
+	return &SiteSchedule{
+		Login:      site.Login.times(day, day.Add(24*time.Hour)),
+		Evaluation: site.Evaluation.times(day, day.Add(48*time.Hour)),
+		Schedule:   site.Schedule.times(day, day.Add(48*time.Hour)),
+	}
+}
+
+// SiteState is the state of the participant site at a time.
+type SiteState struct {
+	Time       time.Time
+	Before     bool // before the first feature opens
+	Login      bool // login with code
+	Evaluation bool // login with emailed link, enter evaluations
+	Schedule   bool // view schedule
+}
+
+// Open returns true if any feature is open.
+func (ss *SiteState) Open() bool {
+	return ss.Login || ss.Evaluation || ss.Schedule
+}
+
+// Opens returns the time when the first feature opens.
+func (sched *SiteSchedule) Opens() time.Time {
+	t := sched.Login.Open
+	for _, st := range []SiteTimes{sched.Evaluation, sched.Schedule} {
+		if st.Open.Before(t) {
+			t = st.Open
+		}
+	}
+	return t
+}
+
+// ParticipantSiteState returns the state of the participant site at time t.
+func (conf *Conference) ParticipantSiteState(t time.Time) *SiteState {
+	sched := conf.ParticipantSiteSchedule()
+	return &SiteState{
+		Time:       t,
+		Before:     t.Before(sched.Opens()),
+		Login:      sched.Login.contains(t),
+		Evaluation: sched.Evaluation.contains(t),
+		Schedule:   sched.Schedule.contains(t),
+	}
+}
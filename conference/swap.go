@@ -0,0 +1,101 @@
+package conference
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+)
+
+// ClassRegistrations returns the number of participants registered for each
+// class, keyed by class number.
+func (conf *Conference) ClassRegistrations() map[int]int {
+	registered := make(map[int]int)
+	for _, p := range conf.participants {
+		for _, n := range p.Classes {
+			registered[n]++
+		}
+	}
+	return registered
+}
+
+// Available returns the number of open seats in the class given the
+// registration counts from ClassRegistrations. Classes with zero capacity
+// are not limited; ok is false for these classes.
+func (c *Class) Available(registered map[int]int) (n int, ok bool) {
+	if c.Capacity == 0 {
+		return 0, false
+	}
+	return c.Capacity - registered[c.Number], true
+}
+
+// SessionStart returns the time when session starts. The second session
+// starts at the time for the second lunch seating, the earliest start time
+// for the session.
+func (conf *Conference) SessionStart(session int) time.Time {
+	return conf.Date.Add(SessionTimes[session].Start)
+}
+
+// SwapClass returns the participant's classes after changing to class number
+// n at time now. The new class replaces the classes in the sessions of the
+// new class. An error is returned if a session of the new class or a
+// replaced class has started, the participant is an instructor in one of the
+// sessions or the class is full. The error message is suitable for display
+// to the participant.
+func (conf *Conference) SwapClass(p *Participant, n int, now time.Time) ([]int, error) {
+	return conf.swapClass(p, n, now, conf.ClassRegistrations())
+}
+
+func (conf *Conference) swapClass(p *Participant, n int, now time.Time, registered map[int]int) ([]int, error) {
+	c := conf.Class(n)
+	if c == nil {
+		return nil, errors.New("The class was not found.")
+	}
+	for _, pn := range p.Classes {
+		if pn == n {
+			return nil, errors.New("You are already registered for the class.")
+		}
+	}
+	if available, ok := c.Available(registered); ok && available <= 0 {
+		return nil, fmt.Errorf("Class %d is full.", n)
+	}
+	if !now.Before(conf.SessionStart(c.Start)) {
+		return nil, fmt.Errorf("Class %d has started.", n)
+	}
+
+	instructorClasses := conf.ParticipantInstructorClasses(p)
+	for i := c.Start; i <= c.End; i++ {
+		if instructorClasses[i] > 0 {
+			return nil, fmt.Errorf("You are an instructor in session %d.", i+1)
+		}
+	}
+
+	var classes []int
+	for _, pn := range p.Classes {
+		pc := conf.Class(pn)
+		if pc == nil || pc.End < c.Start || pc.Start > c.End {
+			classes = append(classes, pn)
+			continue
+		}
+		if !now.Before(conf.SessionStart(pc.Start)) {
+			return nil, fmt.Errorf("Your class %d has started.", pn)
+		}
+	}
+	classes = append(classes, n)
+	sort.Ints(classes)
+	return classes, nil
+}
+
+// SwapClasses returns the classes that the participant can change to at time
+// now, sorted by number.
+func (conf *Conference) SwapClasses(p *Participant, now time.Time) []*Class {
+	registered := conf.ClassRegistrations()
+	var result []*Class
+	for _, c := range conf.Classes() {
+		if _, err := conf.swapClass(p, c.Number, now, registered); err == nil {
+			result = append(result, c)
+		}
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].Number < result[j].Number })
+	return result
+}
@@ -0,0 +1,189 @@
+package conference
+
+import (
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// ClassYear summarizes a class in a conference year. Class numbers change
+// each year. Classes are linked across years by series key.
+type ClassYear struct {
+	Year             int          `json:"year"`
+	Number           int          `json:"number"`
+	Title            string       `json:"title"`
+	Series           string       `json:"series,omitempty"`
+	InstructorNames  []string     `json:"instructorNames,omitempty"`
+	InstructorEmails []string     `json:"instructorEmails,omitempty"`
+	Capacity         int          `json:"capacity"`
+	Registered       int          `json:"registered"`
+	Attendance       int          `json:"attendance"` // most evaluations in a session
+	Overall          RatingCounts `json:"overall"`
+}
+
+var (
+	titleNotePattern  = regexp.MustCompile(`\([^)]*\)`)
+	titleDelimPattern = regexp.MustCompile(`[^a-z0-9]+`)
+)
+
+// NormalizeTitle returns the title in a form for comparing titles across
+// years. Case, punctuation and parenthesized notes are ignored.
+func NormalizeTitle(title string) string {
+	title = titleNotePattern.ReplaceAllLiteralString(strings.ToLower(title), " ")
+	return strings.TrimSpace(titleDelimPattern.ReplaceAllLiteralString(title, " "))
+}
+
+// SeriesKey returns the key for linking the class across years. The key is
+// the series from the class planning sheet or the normalized title.
+func (cy *ClassYear) SeriesKey() string {
+	if s := strings.TrimSpace(cy.Series); s != "" {
+		return "series:" + strings.ToLower(s)
+	}
+	return "title:" + NormalizeTitle(cy.Title)
+}
+
+// ClassYears returns the summary of each class for the conference year from
+// the evaluations keyed by participant ID.
+func (conf *Conference) ClassYears(evals map[string]*Evaluation) []*ClassYear {
+	registered := conf.ClassRegistrations()
+	var result []*ClassYear
+	for _, c := range conf.Classes() {
+		cy := &ClassYear{
+			Year:             conf.Date.Year(),
+			Number:           c.Number,
+			Title:            c.Title,
+			Series:           c.Series,
+			InstructorNames:  c.InstructorNames,
+			InstructorEmails: c.InstructorEmails,
+			Capacity:         c.Capacity,
+			Registered:       registered[c.Number],
+		}
+		for _, r := range conf.classEvaluationResults(c, evals) {
+			if r.Evaluations > cy.Attendance {
+				cy.Attendance = r.Evaluations
+			}
+			for v := range r.Overall {
+				cy.Overall[v] += r.Overall[v]
+			}
+		}
+		result = append(result, cy)
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].Number < result[j].Number })
+	return result
+}
+
+// TrendYear is the combined summary of the classes in a trend for a year.
+type TrendYear struct {
+	Year       int
+	Classes    []*ClassYear
+	Capacity   int
+	Registered int
+	Attendance int
+	Overall    RatingCounts
+}
+
+// Limited returns true if the capacity of every class is limited.
+func (ty *TrendYear) Limited() bool {
+	for _, c := range ty.Classes {
+		if c.Capacity == 0 {
+			return false
+		}
+	}
+	return ty.Capacity > 0
+}
+
+// FillRate returns the percentage of capacity registered or zero if capacity
+// is not limited.
+func (ty *TrendYear) FillRate() int {
+	if !ty.Limited() {
+		return 0
+	}
+	return (100*ty.Registered + ty.Capacity/2) / ty.Capacity
+}
+
+// RatingScale returns the average overall rating as a percentage of
+// MaxEvalRating for charts.
+func (ty *TrendYear) RatingScale() int {
+	return int(100*ty.Overall.Average()/MaxEvalRating + 0.5)
+}
+
+func (ty *TrendYear) add(cy *ClassYear) {
+	ty.Classes = append(ty.Classes, cy)
+	ty.Capacity += cy.Capacity
+	ty.Registered += cy.Registered
+	ty.Attendance += cy.Attendance
+	for v := range cy.Overall {
+		ty.Overall[v] += cy.Overall[v]
+	}
+}
+
+// Trend is a series of classes or the classes of an instructor across years.
+type Trend struct {
+	Key   string
+	Name  string
+	Years []*TrendYear // sorted by year
+
+	nameYear int
+}
+
+// Year returns the summary for year or nil if there were no classes in the
+// year.
+func (t *Trend) Year(year int) *TrendYear {
+	for _, ty := range t.Years {
+		if ty.Year == year {
+			return ty
+		}
+	}
+	return nil
+}
+
+func buildTrends(classYears []*ClassYear, keys func(*ClassYear) map[string]string) []*Trend {
+	trends := make(map[string]*Trend)
+	for _, cy := range classYears {
+		for key, name := range keys(cy) {
+			t := trends[key]
+			if t == nil {
+				t = &Trend{Key: key}
+				trends[key] = t
+			}
+			ty := t.Year(cy.Year)
+			if ty == nil {
+				ty = &TrendYear{Year: cy.Year}
+				t.Years = append(t.Years, ty)
+			}
+			ty.add(cy)
+			// Use the name from the most recent year.
+			if t.Name == "" || cy.Year > t.nameYear {
+				t.Name = name
+				t.nameYear = cy.Year
+			}
+		}
+	}
+	var result []*Trend
+	for _, t := range trends {
+		sort.Slice(t.Years, func(i, j int) bool { return t.Years[i].Year < t.Years[j].Year })
+		result = append(result, t)
+	}
+	sort.Slice(result, func(i, j int) bool { return strings.ToLower(result[i].Name) < strings.ToLower(result[j].Name) })
+	return result
+}
+
+// SeriesTrends returns the trends for class series.
+func SeriesTrends(classYears []*ClassYear) []*Trend {
+	return buildTrends(classYears, func(cy *ClassYear) map[string]string {
+		return map[string]string{cy.SeriesKey(): cy.Title}
+	})
+}
+
+// InstructorTrends returns the trends for instructors. Instructors are
+// identified by name. The planning sheet lists names and email addresses
+// separately, so an address cannot be matched to a name.
+func InstructorTrends(classYears []*ClassYear) []*Trend {
+	return buildTrends(classYears, func(cy *ClassYear) map[string]string {
+		keys := make(map[string]string)
+		for _, name := range cy.InstructorNames {
+			keys[NormalizeTitle(name)] = name
+		}
+		return keys
+	})
+}
@@ -3,9 +3,10 @@ package dashboard
 import (
 	"html/template"
 	"net/http"
-	"strings"
 
 	"github.com/seaptc/seaptc/application"
+	"github.com/seaptc/seaptc/conference"
+	"github.com/seaptc/seaptc/store"
 )
 
 type service struct {
@@ -25,6 +26,64 @@ func (s *service) Setup(app *application.Application) (string, interface{}, erro
 	return "dashboard", &s.templates, nil
 }
 
+func (s *service) HandlerPermissions() map[string]conference.Permission {
+	return map[string]conference.Permission{
+		"/dashboard":                          conference.PermissionPublic,
+		"/dashboard/":                         conference.PermissionPublic,
+		"/dashboard/admin":                    conference.PermissionView,
+		"/dashboard/archiveClassYears":        conference.PermissionAdmin,
+		"/dashboard/audit":                    conference.PermissionAdmin,
+		"/dashboard/blankForm":                conference.PermissionPublic,
+		"/dashboard/classes":                  conference.PermissionPublic,
+		"/dashboard/classes/":                 conference.PermissionPublic,
+		"/dashboard/classResults/":            conference.PermissionPublic,
+		"/dashboard/classrooms":               conference.PermissionView,
+		"/dashboard/commitClasses":            conference.PermissionClasses,
+		"/dashboard/configuration":            conference.PermissionAdmin,
+		"/dashboard/deleteClassYears":         conference.PermissionAdmin,
+		"/dashboard/doorSigns":                conference.PermissionView,
+		"/dashboard/duplicates":               conference.PermissionRegistration,
+		"/dashboard/email":                    conference.PermissionEmail,
+		"/dashboard/email/":                   conference.PermissionEmail,
+		"/dashboard/evalCode":                 conference.PermissionEvaluations,
+		"/dashboard/evaluations/":             conference.PermissionEvaluations,
+		"/dashboard/forms":                    conference.PermissionPrint,
+		"/dashboard/forms/":                   conference.PermissionPrint,
+		"/dashboard/history":                  conference.PermissionAdmin,
+		"/dashboard/historyDiff":              conference.PermissionAdmin,
+		"/dashboard/instructor":               conference.PermissionPublic,
+		"/dashboard/instructor/deleteHandout": conference.PermissionPublic,
+		"/dashboard/instructor/handout":       conference.PermissionPublic,
+		"/dashboard/instructor/roster":        conference.PermissionPublic,
+		"/dashboard/instructor/signIn":        conference.PermissionPublic,
+		"/dashboard/instructor/uploadHandout": conference.PermissionPublic,
+		"/dashboard/kiosk":                    conference.PermissionView,
+		"/dashboard/login":                    conference.PermissionPublic,
+		"/dashboard/loginAttempts":            conference.PermissionAdmin,
+		"/dashboard/logout":                   conference.PermissionPublic,
+		"/dashboard/lunchCount":               conference.PermissionPublic,
+		"/dashboard/lunchList":                conference.PermissionLunch,
+		"/dashboard/lunchStickers":            conference.PermissionLunch,
+		"/dashboard/mergeParticipants":        conference.PermissionRegistration,
+		"/dashboard/participants":             conference.PermissionView,
+		"/dashboard/participants/":            conference.PermissionView,
+		"/dashboard/refreshClasses":           conference.PermissionClasses,
+		"/dashboard/reprintForms":             conference.PermissionPrint,
+		"/dashboard/resetLoginAttempts":       conference.PermissionAdmin,
+		"/dashboard/restoreBlob":              conference.PermissionAdmin,
+		"/dashboard/sendEmail":                conference.PermissionEmail,
+		"/dashboard/setInstructorClasses":     conference.PermissionRegistration,
+		"/dashboard/setParticipantOverride":   conference.PermissionEditParticipants,
+		"/dashboard/sitePreview":              conference.PermissionView,
+		"/dashboard/trends":                   conference.PermissionEvaluations,
+		"/dashboard/uploadClassYears":         conference.PermissionAdmin,
+		"/dashboard/uploadClasses":            conference.PermissionClasses,
+		"/dashboard/uploadRegistrations":      conference.PermissionRegistration,
+		"/dashboard/vcard":                    conference.PermissionPublic,
+		"/login/callback":                     conference.PermissionPublic,
+	}
+}
+
 func (s *service) Execute(fn interface{}, w http.ResponseWriter, r *http.Request) {
 	rc := &requestContext{}
 	err := rc.Init(s.Application, w, r)
@@ -32,14 +91,14 @@ func (s *service) Execute(fn interface{}, w http.ResponseWriter, r *http.Request
 		s.handleError(rc, err)
 		return
 	}
+	if err := rc.CheckCSRF(); err != nil {
+		s.handleError(rc, err)
+		return
+	}
 
-	if c, _ := rc.Request.Cookie("staff"); c != nil {
-		if s, ok := application.VerifySignature(rc.Conference.Configuration.CookieKey, c.Value); ok {
-			parts, err := application.DecodeStringsFromCookie(s)
-			if err == nil && len(parts) == 1 {
-				rc.StaffID = strings.ToLower(parts[0])
-			}
-		}
+	rc.StaffID = rc.StaffCookieID()
+	if rc.StaffID != "" {
+		rc.Ctx = store.WithActor(rc.Ctx, "staff:"+rc.StaffID)
 	}
 
 	if rc.ConfFromCache && rc.Conference.IsStaff(rc.StaffID) {
@@ -51,6 +110,11 @@ func (s *service) Execute(fn interface{}, w http.ResponseWriter, r *http.Request
 		}
 	}
 
+	if !rc.Can(rc.Permission) {
+		s.handleError(rc, application.ErrForbidden)
+		return
+	}
+
 	err = fn.(func(*service, *requestContext) error)(s, rc)
 	if err != nil {
 		s.handleError(rc, err)
@@ -77,3 +141,8 @@ func (rc *requestContext) IsStaff() bool {
 func (rc *requestContext) IsAdmin() bool {
 	return rc.Conference.IsAdmin(rc.StaffID)
 }
+
+// Can returns true if the logged in staff member has the permission.
+func (rc *requestContext) Can(p conference.Permission) bool {
+	return rc.Conference.HasPermission(rc.StaffID, p)
+}
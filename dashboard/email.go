@@ -0,0 +1,407 @@
+package dashboard
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"net/url"
+	"path/filepath"
+	"sort"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/seaptc/seaptc/application"
+	"github.com/seaptc/seaptc/conference"
+	"github.com/seaptc/seaptc/mail"
+	"github.com/seaptc/seaptc/store"
+)
+
+// Email campaigns send a templated message to each recipient address. The
+// message templates are in assets/templates/email/{campaign}.txt and define
+// the templates "subject" and "body". Participants who share an address, for
+// example a family, receive one message.
+//
+// A message is sent at most once per conference year, campaign and address.
+// The send log records each message. Messages that fail are sent again with
+// the next batch. Addresses that unsubscribe with the link in the message are
+// skipped.
+
+// emailBatchSize is the maximum number of messages sent by one request.
+const emailBatchSize = 50
+
+const unsubscribeMaxAge = 365 * 24 * 3600
+
+type emailRecipient struct {
+	Address      string
+	Participants []*conference.Participant
+	Classes      []*rosterClass
+}
+
+type rosterClass struct {
+	*conference.Class
+	Participants []*conference.Participant
+	URL          string
+}
+
+type emailCampaign struct {
+	Name        string
+	Title       string
+	Description string
+	recipients  func(s *service, rc *requestContext) ([]*emailRecipient, error)
+}
+
+var emailCampaigns = []*emailCampaign{
+	{
+		Name:        "schedule",
+		Title:       "Schedules",
+		Description: "Each participant's schedule. Send the week before the conference.",
+		recipients:  scheduleRecipients,
+	},
+	{
+		Name:        "roster",
+		Title:       "Instructor Rosters",
+		Description: "Class rosters for instructors listed in the class sheet.",
+		recipients:  rosterRecipients,
+	},
+	{
+		Name:        "evalReminder",
+		Title:       "Evaluation Reminders",
+		Description: "Reminder for participants who have not evaluated all of their sessions. Send after the conference.",
+		recipients:  evalReminderRecipients,
+	},
+}
+
+func findEmailCampaign(name string) *emailCampaign {
+	for _, c := range emailCampaigns {
+		if c.Name == name {
+			return c
+		}
+	}
+	return nil
+}
+
+// recipientsByEmail returns a recipient for each address of participants.
+func recipientsByEmail(participants []*conference.Participant) []*emailRecipient {
+	m := make(map[string]*emailRecipient)
+	var result []*emailRecipient
+	for _, p := range participants {
+		for _, e := range p.Emails() {
+			address := strings.ToLower(strings.TrimSpace(e))
+			if address == "" {
+				continue
+			}
+			r := m[address]
+			if r == nil {
+				r = &emailRecipient{Address: address}
+				m[address] = r
+				result = append(result, r)
+			}
+			if n := len(r.Participants); n == 0 || r.Participants[n-1] != p {
+				r.Participants = append(r.Participants, p)
+			}
+		}
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].Address < result[j].Address })
+	return result
+}
+
+func scheduleRecipients(s *service, rc *requestContext) ([]*emailRecipient, error) {
+	participants := rc.Conference.Participants()
+	conference.SortParticipants(participants, "")
+	return recipientsByEmail(participants), nil
+}
+
+func rosterRecipients(s *service, rc *requestContext) ([]*emailRecipient, error) {
+	m := make(map[string]*emailRecipient)
+	var result []*emailRecipient
+	for _, class := range rc.Conference.Classes() {
+		if len(class.InstructorEmails) == 0 {
+			continue
+		}
+		roster := &rosterClass{Class: class, Participants: rc.Conference.ClassParticipants(class)}
+		conference.SortParticipants(roster.Participants, "")
+		if len(class.AccessToken) >= 4 {
+			roster.URL = fmt.Sprintf("%s/dashboard/classes/%d?t=%s", s.siteURL(rc), class.Number, class.AccessToken)
+		}
+		for _, e := range class.InstructorEmails {
+			address := strings.ToLower(strings.TrimSpace(e))
+			if address == "" {
+				continue
+			}
+			r := m[address]
+			if r == nil {
+				r = &emailRecipient{Address: address}
+				m[address] = r
+				result = append(result, r)
+			}
+			r.Classes = append(r.Classes, roster)
+		}
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].Address < result[j].Address })
+	return result, nil
+}
+
+func evalReminderRecipients(s *service, rc *requestContext) ([]*emailRecipient, error) {
+	evals, err := s.Store.GetEvaluations(rc.Ctx)
+	if err != nil {
+		return nil, err
+	}
+	var participants []*conference.Participant
+	for _, p := range rc.Conference.Participants() {
+		if missingSessionEvaluations(rc.Conference, p, evals[p.ID]) {
+			participants = append(participants, p)
+		}
+	}
+	conference.SortParticipants(participants, "")
+	return recipientsByEmail(participants), nil
+}
+
+// missingSessionEvaluations returns true if the participant attended a
+// session as a student and did not evaluate the session.
+func missingSessionEvaluations(conf *conference.Conference, p *conference.Participant, eval *conference.Evaluation) bool {
+	evaluated := make(map[int]bool)
+	if eval != nil {
+		for _, se := range eval.Sessions {
+			evaluated[se.Session] = true
+		}
+	}
+	for _, sc := range conf.ParticipantSessionClasses(p) {
+		if sc.Number != 0 && !sc.Instructor && !evaluated[sc.Session] {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *service) siteURL(rc *requestContext) string {
+	return fmt.Sprintf("%s://%s", s.Protocol, rc.Request.Host)
+}
+
+type emailData struct {
+	*emailRecipient
+	Conference     *conference.Conference
+	SiteURL        string
+	UnsubscribeURL string
+}
+
+func (s *service) renderEmail(rc *requestContext, c *emailCampaign, r *emailRecipient) (*mail.Message, error) {
+	t, err := template.ParseFiles(filepath.Join(s.AssetsDir, "templates", "email", c.Name+".txt"))
+	if err != nil {
+		return nil, err
+	}
+	token := application.SignToken(rc.Conference.Configuration.CookieKey, unsubscribeMaxAge, application.UnsubscribePurpose, r.Address)
+	data := &emailData{
+		emailRecipient: r,
+		Conference:     rc.Conference,
+		SiteURL:        s.siteURL(rc),
+		UnsubscribeURL: fmt.Sprintf("%s/unsubscribe?t=%s", s.siteURL(rc), url.QueryEscape(token)),
+	}
+	var subject, body bytes.Buffer
+	if err := t.ExecuteTemplate(&subject, "subject", data); err != nil {
+		return nil, err
+	}
+	if err := t.ExecuteTemplate(&body, "body", data); err != nil {
+		return nil, err
+	}
+	return &mail.Message{
+		To:      []string{r.Address},
+		Subject: strings.TrimSpace(subject.String()),
+		Body:    strings.TrimLeft(body.String(), "\n"),
+		Headers: map[string]string{"List-Unsubscribe": "<" + data.UnsubscribeURL + ">"},
+	}, nil
+}
+
+// emailStatus is the status of a campaign recipient.
+type emailStatus struct {
+	*emailRecipient
+	Entry        *store.EmailLogEntry
+	Unsubscribed bool
+}
+
+// Pending returns true if a message should be sent to the recipient.
+func (es *emailStatus) Pending() bool {
+	return !es.Unsubscribed && (es.Entry == nil || es.Entry.Error != "")
+}
+
+func (s *service) emailStatus(rc *requestContext, c *emailCampaign) ([]*emailStatus, error) {
+	recipients, err := c.recipients(s, rc)
+	if err != nil {
+		return nil, err
+	}
+	entries, err := s.Store.GetEmailLog(rc.Ctx, rc.Conference.Configuration.Year, c.Name)
+	if err != nil {
+		return nil, err
+	}
+	unsubscribed, err := s.Store.GetUnsubscribed(rc.Ctx)
+	if err != nil {
+		return nil, err
+	}
+	log := make(map[string]*store.EmailLogEntry)
+	for _, e := range entries {
+		log[e.Address] = e
+	}
+	result := make([]*emailStatus, len(recipients))
+	for i, r := range recipients {
+		result[i] = &emailStatus{
+			emailRecipient: r,
+			Entry:          log[r.Address],
+			Unsubscribed:   unsubscribed[r.Address],
+		}
+	}
+	return result, nil
+}
+
+type emailCounts struct {
+	Recipients, Sent, Sending, Failed, Unsubscribed, Pending int
+}
+
+func countEmailStatus(status []*emailStatus) *emailCounts {
+	var counts emailCounts
+	for _, es := range status {
+		counts.Recipients++
+		switch {
+		case es.Entry != nil && es.Entry.Sent():
+			counts.Sent++
+		case es.Entry != nil && es.Entry.Sending:
+			counts.Sending++
+		case es.Unsubscribed:
+			counts.Unsubscribed++
+		case es.Entry != nil:
+			counts.Failed++
+		}
+		if es.Pending() {
+			counts.Pending++
+		}
+	}
+	return &counts
+}
+
+func (s *service) Serve_dashboard_email(rc *requestContext) error {
+	type campaignSummary struct {
+		*emailCampaign
+		*emailCounts
+	}
+	var data struct {
+		Campaigns []*campaignSummary
+	}
+	for _, c := range emailCampaigns {
+		status, err := s.emailStatus(rc, c)
+		if err != nil {
+			return err
+		}
+		data.Campaigns = append(data.Campaigns, &campaignSummary{c, countEmailStatus(status)})
+	}
+	return rc.Respond(s.templates.Email, http.StatusOK, &data)
+}
+
+func (s *service) Serve_dashboard_email_(rc *requestContext) error {
+	c := findEmailCampaign(strings.TrimPrefix(rc.Request.URL.Path, "/dashboard/email/"))
+	if c == nil {
+		return application.ErrNotFound
+	}
+	status, err := s.emailStatus(rc, c)
+	if err != nil {
+		return err
+	}
+
+	var data = struct {
+		Campaign       *emailCampaign
+		Counts         *emailCounts
+		Status         []*emailStatus
+		Preview        *mail.Message
+		Address        string
+		MailConfigured bool
+	}{
+		Campaign:       c,
+		Counts:         countEmailStatus(status),
+		Status:         status,
+		Address:        strings.ToLower(rc.FormValue("address")),
+		MailConfigured: s.MailConfigured(rc.Conference),
+	}
+
+	// Preview the selected recipient or the first pending recipient.
+	var preview *emailRecipient
+	for _, es := range status {
+		if es.Address == data.Address || (data.Address == "" && es.Pending()) {
+			preview = es.emailRecipient
+			break
+		}
+	}
+	if preview != nil {
+		data.Address = preview.Address
+		data.Preview, err = s.renderEmail(rc, c, preview)
+		if err != nil {
+			return err
+		}
+	}
+	return rc.Respond(s.templates.EmailCampaign, http.StatusOK, &data)
+}
+
+func (s *service) Serve_dashboard_sendEmail(rc *requestContext) error {
+	if !rc.IsPost() {
+		return application.ErrBadRequest
+	}
+	c := findEmailCampaign(rc.FormValue("campaign"))
+	if c == nil {
+		return application.ErrBadRequest
+	}
+	mailer, err := s.Mailer(rc.Conference)
+	if err != nil {
+		return err
+	}
+	if mailer == nil {
+		return rc.Redirect("/dashboard/email/"+c.Name, application.FlashError,
+			"Mail is not configured. Set mailSender in the configuration.")
+	}
+	status, err := s.emailStatus(rc, c)
+	if err != nil {
+		return err
+	}
+
+	attempted, failed := 0, 0
+	for _, es := range status {
+		if attempted >= emailBatchSize {
+			break
+		}
+		if !es.Pending() {
+			continue
+		}
+		m, err := s.renderEmail(rc, c, es.emailRecipient)
+		if err != nil {
+			return err
+		}
+		entry := &store.EmailLogEntry{
+			Year:     rc.Conference.Configuration.Year,
+			Campaign: c.Name,
+			Address:  es.Address,
+			Time:     time.Now(),
+			Subject:  m.Subject,
+		}
+		// Claim the address before sending so that concurrent requests do
+		// not send the same message.
+		claimed, err := s.Store.ClaimEmailLogEntry(rc.Ctx, entry)
+		if err != nil {
+			return err
+		}
+		if !claimed {
+			continue
+		}
+		attempted++
+		if err := mailer.Send(rc.Ctx, m); err != nil {
+			entry.Error = err.Error()
+			failed++
+		}
+		if err := s.Store.PutEmailLogEntry(rc.Ctx, entry); err != nil {
+			return err
+		}
+	}
+
+	remaining := countEmailStatus(status).Pending - (attempted - failed)
+	severity := application.FlashInfo
+	if failed > 0 {
+		severity = application.FlashError
+	}
+	return rc.Redirect("/dashboard/email/"+c.Name, severity,
+		"Sent %d messages, %d failed, %d remaining", attempted-failed, failed, remaining)
+}
@@ -72,10 +72,6 @@ var formOptions = map[string]*struct {
 }
 
 func (s *service) Serve_dashboard_forms(rc *requestContext) error {
-	if !rc.IsStaff() {
-		return application.ErrForbidden
-	}
-
 	if rc.IsPost() {
 		printSignatures := make(map[string]string)
 		for _, idsig := range rc.Request.Form["idsig"] {
@@ -119,9 +115,6 @@ func (s *service) Serve_dashboard_forms(rc *requestContext) error {
 }
 
 func (s *service) Serve_dashboard_forms_(rc *requestContext) error {
-	if !rc.IsStaff() {
-		return application.ErrForbidden
-	}
 	p := rc.Conference.Participant(strings.TrimPrefix(rc.Request.URL.Path, "/dashboard/forms/"))
 	if p == nil {
 		return application.ErrNotFound
@@ -210,9 +203,6 @@ func (s *service) Serve_dashboard_vcard(rc *requestContext) error {
 }
 
 func (s *service) Serve_dashboard_reprintForms(rc *requestContext) error {
-	if !rc.IsStaff() {
-		return application.ErrForbidden
-	}
 	if rc.IsPost() {
 		ids := rc.Request.Form["id"]
 		m := make(map[string]string, len(ids))
@@ -7,6 +7,7 @@ import (
 	"html/template"
 	"io"
 	"net/http"
+	"net/url"
 	"sort"
 	"strconv"
 	"strings"
@@ -17,26 +18,43 @@ import (
 	"github.com/seaptc/seaptc/dk"
 	"github.com/seaptc/seaptc/log"
 	"github.com/seaptc/seaptc/sheet"
+	"github.com/seaptc/seaptc/store"
+	"github.com/seaptc/seaptc/throttle"
 )
 
 type templates struct {
 	Admin,
+	Audit,
 	Class,
 	Classes,
+	ClassesReport,
+	ClassResults,
 	Configuration,
+	Duplicates,
+	Email,
+	EmailCampaign,
 	EvalCode,
 	Evaluation,
+	History,
+	HistoryDiff,
 	Index,
+	Instructor,
 	LunchCount,
+	LoginAttempts,
 	LunchList,
 	Participant,
 	Participants,
 	Reprint,
+	SitePreview,
+	Trends,
 	Error *template.Template `template:".,root.html,../common.html"`
 
 	Form          *template.Template `template:".,../common.html"`
 	LunchStickers *template.Template `template:"."`
 	Classrooms    *template.Template `template:"."`
+	Kiosk         *template.Template `template:"."`
+	DoorSigns     *template.Template `template:"."`
+	SignIn        *template.Template `template:"."`
 }
 
 func (s *service) Serve_dashboard_(rc *requestContext) error {
@@ -76,33 +94,92 @@ func (s *service) Serve_dashboard(rc *requestContext) error {
 }
 
 func (s *service) Serve_dashboard_admin(rc *requestContext) error {
-	if !rc.IsStaff() {
-		return application.ErrForbidden
-	}
 	return rc.Respond(s.templates.Admin, http.StatusOK, nil)
 }
 
 func (s *service) Serve_dashboard_refreshClasses(rc *requestContext) error {
-	if !rc.IsStaff() {
-		return application.ErrForbidden
+	if !rc.IsPost() {
+		return application.ErrBadRequest
+	}
+	report, err := sheet.Fetch(rc.Ctx, rc.Conference.Configuration.ClassesSheetURL)
+	if err != nil {
+		return err
+	}
+	return s.reviewClasses(rc, "planning sheet", report)
+}
+
+func (s *service) Serve_dashboard_uploadClasses(rc *requestContext) error {
+	if !rc.IsPost() {
+		return application.ErrBadRequest
+	}
+
+	f, header, err := rc.Request.FormFile("file")
+	if err == http.ErrMissingFile {
+		return &application.HTTPError{Status: http.StatusBadRequest, Message: "File is required."}
+	} else if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	report, err := sheet.ParseFile(header.Filename, f)
+	if err != nil {
+		return &application.HTTPError{Status: http.StatusBadRequest, Message: err.Error(), Err: err}
+	}
+	return s.reviewClasses(rc, header.Filename, report)
+}
+
+// reviewClasses displays the validation report for classes read from the
+// planning sheet or an uploaded file. The classes are kept in the store as
+// pending classes and stored when the user commits the report.
+func (s *service) reviewClasses(rc *requestContext, source string, report *sheet.Report) error {
+	report.Compare(rc.Conference.Classes())
+	data := struct {
+		Source    string
+		Report    *sheet.Report
+		Errors    []*sheet.Problem
+		Warnings  []*sheet.Problem
+		PendingID string
+	}{
+		Source:   source,
+		Report:   report,
+		Errors:   report.Errors(),
+		Warnings: report.Warnings(),
+	}
+	if len(data.Errors) == 0 {
+		var err error
+		data.PendingID, err = s.Store.PutPendingClasses(rc.Ctx, report.Classes)
+		if err != nil {
+			return err
+		}
 	}
+	return rc.Respond(s.templates.ClassesReport, http.StatusOK, &data)
+}
+
+func (s *service) Serve_dashboard_commitClasses(rc *requestContext) error {
 	if !rc.IsPost() {
 		return application.ErrBadRequest
 	}
-	classes, err := sheet.GetClasses(rc.Ctx, rc.Conference.Configuration.ClassesSheetURL)
+	pendingID := rc.FormValue("pending")
+	classes, err := s.Store.GetPendingClasses(rc.Ctx, pendingID)
 	if err != nil {
 		return err
 	}
+	if classes == nil {
+		return &application.HTTPError{Status: http.StatusBadRequest, Message: "The reviewed classes have expired. Load the classes again."}
+	}
+	if err := sheet.Validate(classes).Err(); err != nil {
+		return &application.HTTPError{Status: http.StatusBadRequest, Message: err.Error(), Err: err}
+	}
 	if err := s.Store.PutClasses(rc.Ctx, classes); err != nil {
 		return err
 	}
+	if err := s.Store.DeletePendingClasses(rc.Ctx, pendingID); err != nil {
+		return err
+	}
 	return rc.Redirect("/dashboard/classes", application.FlashInfo, "%d classes updated", len(classes))
 }
 
 func (s *service) Serve_dashboard_uploadRegistrations(rc *requestContext) error {
-	if !rc.IsAdmin() {
-		return application.ErrForbidden
-	}
 	if !rc.IsPost() {
 		return application.ErrBadRequest
 	}
@@ -128,16 +205,171 @@ func (s *service) importRegistrations(rc *requestContext, data io.Reader) error
 		return err
 	}
 
+	conf, _, err := s.Store.GetConference(rc.Ctx, true)
+	if err != nil {
+		return err
+	}
+	if duplicates := conference.FindDuplicates(conf.Participants()); len(duplicates) > 0 {
+		return rc.Redirect("/dashboard/duplicates", "info", "Import %d participants, found %d possible duplicates", len(participants), len(duplicates))
+	}
+
 	return rc.Redirect("/dashboard/admin", "info", "Import %d participants", len(participants))
 }
 
-func (s *service) Serve_dashboard_classes(rc *requestContext) error {
-	registered := make(map[int]int)
-	for _, p := range rc.Conference.Participants() {
-		for _, n := range p.Classes {
-			registered[n]++
+func (s *service) Serve_dashboard_duplicates(rc *requestContext) error {
+	var data = struct {
+		Duplicates     []*conference.Duplicate
+		SessionClasses interface{}
+	}{
+		Duplicates:     conference.FindDuplicates(rc.Conference.Participants()),
+		SessionClasses: rc.Conference.ParticipantSessionClasses,
+	}
+	return rc.Respond(s.templates.Duplicates, http.StatusOK, &data)
+}
+
+func (s *service) Serve_dashboard_mergeParticipants(rc *requestContext) error {
+	if !rc.IsPost() {
+		return application.ErrBadRequest
+	}
+
+	survivor := rc.Conference.Participant(rc.FormValue("survivor"))
+	duplicate := rc.Conference.Participant(rc.FormValue("duplicate"))
+	if survivor == nil || duplicate == nil || survivor == duplicate {
+		return application.ErrNotFound
+	}
+
+	if err := s.Store.MergeParticipants(rc.Ctx, survivor.ID, duplicate.ID); err != nil {
+		return err
+	}
+	return rc.Redirect("/dashboard/duplicates", application.FlashInfo, "Merged %s (%s) into %s (%s)",
+		duplicate.Name(), duplicate.RegistrationNumber, survivor.Name(), survivor.RegistrationNumber)
+}
+
+func (s *service) Serve_dashboard_audit(rc *requestContext) error {
+	q := &store.AuditQuery{
+		Actor:     rc.FormValue("actor"),
+		Operation: rc.FormValue("operation"),
+		Target:    rc.FormValue("target"),
+	}
+	if before := rc.FormValue("before"); before != "" {
+		t, err := time.Parse(time.RFC3339Nano, before)
+		if err != nil {
+			return application.ErrBadRequest
+		}
+		q.Before = t
+	}
+
+	records, err := s.Store.GetAuditRecords(rc.Ctx, q)
+	if err != nil {
+		return err
+	}
+
+	var data = struct {
+		Query      *store.AuditQuery
+		Operations []string
+		Records    []*store.AuditRecord
+		Next       string
+	}{
+		Query:      q,
+		Operations: store.AuditOperations,
+		Records:    records,
+	}
+	if len(records) > 0 {
+		next := rc.Request.URL.Query()
+		next.Set("before", records[len(records)-1].Time.Format(time.RFC3339Nano))
+		data.Next = "/dashboard/audit?" + next.Encode()
+	}
+	return rc.Respond(s.templates.Audit, http.StatusOK, &data)
+}
+
+func (s *service) Serve_dashboard_loginAttempts(rc *requestContext) error {
+	records, err := s.Throttle.Recent(rc.Ctx)
+	if err != nil {
+		return err
+	}
+	var data = struct {
+		Records []*throttle.Record
+		Now     time.Time
+	}{
+		Records: records,
+		Now:     time.Now(),
+	}
+	return rc.Respond(s.templates.LoginAttempts, http.StatusOK, &data)
+}
+
+func (s *service) Serve_dashboard_resetLoginAttempts(rc *requestContext) error {
+	if !rc.IsPost() {
+		return application.ErrBadRequest
+	}
+	key := rc.FormValue("key")
+	if err := s.Throttle.Reset(rc.Ctx, key); err != nil {
+		return err
+	}
+	return rc.Redirect("/dashboard/loginAttempts", application.FlashInfo, "Reset %s", key)
+}
+
+func (s *service) Serve_dashboard_history(rc *requestContext) error {
+	var data = struct {
+		Names            []string
+		Name             string
+		ParticipantChunk bool
+		Versions         []*store.BlobVersion
+	}{
+		Names: store.HistoryBlobNames,
+		Name:  rc.FormValue("name"),
+	}
+	data.ParticipantChunk = store.IsParticipantChunk(data.Name)
+	if data.Name != "" {
+		var err error
+		data.Versions, err = s.Store.BlobHistory(rc.Ctx, data.Name)
+		if err != nil {
+			return &application.HTTPError{Status: http.StatusNotFound, Message: err.Error(), Err: err}
 		}
 	}
+	return rc.Respond(s.templates.History, http.StatusOK, &data)
+}
+
+func (s *service) Serve_dashboard_historyDiff(rc *requestContext) error {
+	name := rc.FormValue("name")
+	from, err := strconv.ParseInt(rc.FormValue("from"), 10, 64)
+	if err != nil {
+		return application.ErrBadRequest
+	}
+	to, err := strconv.ParseInt(rc.FormValue("to"), 10, 64)
+	if err != nil {
+		return application.ErrBadRequest
+	}
+	lines, err := s.Store.DiffBlobVersions(rc.Ctx, name, from, to)
+	if err != nil {
+		return &application.HTTPError{Status: http.StatusNotFound, Message: err.Error(), Err: err}
+	}
+
+	var data = struct {
+		Name     string
+		From, To int64
+		Lines    []*store.DiffLine
+	}{name, from, to, lines}
+	return rc.Respond(s.templates.HistoryDiff, http.StatusOK, &data)
+}
+
+func (s *service) Serve_dashboard_restoreBlob(rc *requestContext) error {
+	if !rc.IsPost() {
+		return application.ErrBadRequest
+	}
+
+	name := rc.FormValue("name")
+	version, err := strconv.ParseInt(rc.FormValue("version"), 10, 64)
+	if err != nil {
+		return application.ErrBadRequest
+	}
+	if err := s.Store.RestoreBlobVersion(rc.Ctx, name, version); err != nil {
+		return err
+	}
+	return rc.Redirect("/dashboard/history?name="+url.QueryEscape(name), application.FlashInfo, "Restored %s version %d", name, version)
+}
+
+func (s *service) Serve_dashboard_classes(rc *requestContext) error {
+	registered := rc.Conference.ClassRegistrations()
 
 	classes := rc.Conference.Classes()
 
@@ -178,10 +410,11 @@ func (s *service) Serve_dashboard_classes(rc *requestContext) error {
 			return strconv.Itoa(n)
 		},
 		Available: func(c *conference.Class) string {
-			if c.Capacity == 0 {
+			n, ok := c.Available(registered)
+			if !ok {
 				return ""
 			}
-			return strconv.Itoa(c.Capacity - registered[c.Number])
+			return strconv.Itoa(n)
 		},
 	}
 	return rc.Respond(s.templates.Classes, http.StatusOK, &data)
@@ -203,7 +436,7 @@ func (s *service) Serve_dashboard_classes_(rc *requestContext) error {
 		Lunch             *conference.Lunch
 	}{
 		Class:          class,
-		InstructorView: rc.IsStaff(),
+		InstructorView: rc.Can(conference.PermissionView),
 		Lunch:          rc.Conference.ClassLunch(class),
 	}
 
@@ -238,10 +471,6 @@ func (s *service) Serve_dashboard_classes_(rc *requestContext) error {
 }
 
 func (s *service) Serve_dashboard_participants(rc *requestContext) error {
-	if !rc.IsStaff() {
-		return application.ErrForbidden
-	}
-
 	participants := rc.Conference.Participants()
 	conference.SortParticipants(participants, rc.FormValue("sort"))
 
@@ -256,31 +485,124 @@ func (s *service) Serve_dashboard_participants(rc *requestContext) error {
 }
 
 func (s *service) Serve_dashboard_participants_(rc *requestContext) error {
-	if !rc.IsStaff() {
-		return application.ErrForbidden
-	}
-
 	id := strings.TrimPrefix(rc.Request.URL.Path, "/dashboard/participants/")
 	participant := rc.Conference.Participant(id)
 	if participant == nil {
 		return application.ErrNotFound
 	}
 
+	type overrideField struct {
+		Name       string
+		Label      string
+		Imported   string
+		Value      string
+		Overridden bool
+		Options    []string // nil for free text
+	}
+
 	var data = struct {
 		Participant       *conference.Participant
 		Schedule          []*conference.ScheduleItem
 		InstructorClasses []int
+		OverrideFields    []*overrideField
 	}{
 		Participant:       participant,
 		Schedule:          rc.Conference.ParticipantSchedule(participant),
 		InstructorClasses: rc.Conference.ParticipantInstructorClasses(participant),
 	}
+
+	override := participant.Override()
+	if override == nil {
+		override = &conference.ParticipantOverride{}
+	}
+	for _, f := range participantOverrideFields {
+		data.OverrideFields = append(data.OverrideFields, &overrideField{
+			Name:       f.name,
+			Label:      f.label,
+			Imported:   f.value(participant.Imported()),
+			Value:      f.value(participant),
+			Overridden: *f.override(override) != nil,
+			Options:    f.options(rc.Conference),
+		})
+	}
 	return rc.Respond(s.templates.Participant, http.StatusOK, &data)
 }
 
+// participantOverrideFields is the list of participant fields that staff can
+// override.
+var participantOverrideFields = []struct {
+	name     string
+	label    string
+	value    func(*conference.Participant) string
+	override func(*conference.ParticipantOverride) **string
+
+	// options returns the allowed values or nil for free text.
+	options func(*conference.Conference) []string
+}{
+	{"nickname", "Nickname",
+		func(p *conference.Participant) string { return p.Nickname },
+		func(o *conference.ParticipantOverride) **string { return &o.Nickname },
+		noOverrideOptions},
+	{"lunchOption", "Lunch Option",
+		func(p *conference.Participant) string { return p.LunchOption },
+		func(o *conference.ParticipantOverride) **string { return &o.LunchOption },
+		// The lunch count and stickers group participants by option, so
+		// limit the override to the options from registration.
+		func(conf *conference.Conference) []string { return append([]string{""}, conf.LunchOptions()...) }},
+	{"unitType", "Unit Type",
+		func(p *conference.Participant) string { return p.UnitType },
+		func(o *conference.ParticipantOverride) **string { return &o.UnitType },
+		noOverrideOptions},
+	{"unitNumber", "Unit Number",
+		func(p *conference.Participant) string { return p.UnitNumber },
+		func(o *conference.ParticipantOverride) **string { return &o.UnitNumber },
+		noOverrideOptions},
+}
+
+func noOverrideOptions(*conference.Conference) []string { return nil }
+
+func (s *service) Serve_dashboard_setParticipantOverride(rc *requestContext) error {
+	if !rc.IsPost() {
+		return application.ErrBadRequest
+	}
+
+	participant := rc.Conference.Participant(rc.FormValue("id"))
+	if participant == nil {
+		return application.ErrNotFound
+	}
+
+	var override conference.ParticipantOverride
+	for _, f := range participantOverrideFields {
+		if rc.FormValue("override_"+f.name) == "" {
+			continue
+		}
+		value := strings.TrimSpace(rc.FormValue(f.name))
+		if options := f.options(rc.Conference); options != nil {
+			found := false
+			for _, o := range options {
+				found = found || o == value
+			}
+			if !found {
+				return &application.HTTPError{Status: http.StatusBadRequest, Message: fmt.Sprintf("Unknown %s %q.", strings.ToLower(f.label), value)}
+			}
+		}
+		*f.override(&override) = &value
+	}
+	// Keep classes changed by the participant unless reverted.
+	if o := participant.Override(); o != nil && rc.FormValue("revertClasses") == "" {
+		override.Classes = o.Classes
+	}
+
+	if err := s.Store.SetParticipantOverride(rc.Ctx, participant.ID, &override); err != nil {
+		return err
+	}
+
+	return rc.Redirect(fmt.Sprintf("/dashboard/participants/%s", participant.ID), application.FlashInfo, "Overrides updated")
+}
+
 func (s *service) Serve_dashboard_setInstructorClasses(rc *requestContext) error {
-	if !rc.IsAdmin() {
-		return application.ErrForbidden
+	if !rc.IsPost() {
+		return application.ErrBadRequest
 	}
 
 	id := rc.FormValue("id")
@@ -298,10 +620,6 @@ func (s *service) Serve_dashboard_setInstructorClasses(rc *requestContext) error
 }
 
 func (s *service) Serve_dashboard_configuration(rc *requestContext) error {
-	if !rc.IsAdmin() {
-		return application.ErrForbidden
-	}
-
 	var data struct {
 		Error  string
 		Config string
@@ -319,6 +637,8 @@ func (s *service) Serve_dashboard_configuration(rc *requestContext) error {
 			data.Error = fmt.Sprintf("%d: %v", strings.Count(data.Config[:offset+1], "\n")+1, err)
 		} else if err != nil {
 			data.Error = err.Error()
+		} else if err := config.Validate(); err != nil {
+			data.Error = err.Error()
 		} else {
 			err = s.Store.PutConfiguration(rc.Ctx, &config)
 			if err != nil {
@@ -357,10 +677,6 @@ func (s *service) Serve_dashboard_lunchCount(rc *requestContext) error {
 }
 
 func (s *service) Serve_dashboard_lunchList(rc *requestContext) error {
-	if !rc.IsAdmin() {
-		return application.ErrForbidden
-	}
-
 	participants := rc.Conference.Participants()
 	participants = conference.FilterParticipants(participants,
 		func(p *conference.Participant) bool { return p.LunchOption != "" })
@@ -387,10 +703,6 @@ func (s *service) Serve_dashboard_lunchList(rc *requestContext) error {
 }
 
 func (s *service) Serve_dashboard_lunchStickers(rc *requestContext) error {
-	if !rc.IsAdmin() {
-		return application.ErrForbidden
-	}
-
 	participants := rc.Conference.Participants()
 	participants = conference.FilterParticipants(participants,
 		func(p *conference.Participant) bool { return p.LunchOption != "" })
@@ -467,59 +779,110 @@ func (s *service) Serve_dashboard_lunchStickers(rc *requestContext) error {
 }
 
 func (s *service) Serve_dashboard_classrooms(rc *requestContext) error {
-	if !rc.IsStaff() {
-		return application.ErrForbidden
+	data := struct {
+		Sessions  [][]*conference.SessionClass
+		Locations map[string][]*conference.Activity
+	}{
+		Sessions:  rc.Conference.Sessions(),
+		Locations: rc.Conference.LocationActivities(),
 	}
+	return rc.Respond(s.templates.Classrooms, http.StatusOK, &data)
+}
 
-	type activity struct {
+// Serve_dashboard_doorSigns prints signs for classroom doors. The room signs
+// have the day's schedule for a location. The session signs have the class
+// in a location for one session.
+func (s *service) Serve_dashboard_doorSigns(rc *requestContext) error {
+	type room struct {
+		Location   string
+		Activities []*conference.Activity
+	}
+
+	type sessionSign struct {
+		*conference.SessionClass
 		Time *conference.ScheduleTime
-		Name string
 	}
 
 	data := struct {
-		Sessions  [][]*conference.SessionClass
-		Locations map[string][]activity
+		Kind         string
+		Location     string
+		Session      int // 1-based, 0 for all
+		Sessions     []int
+		Locations    []string
+		Rooms        []*room
+		SessionSigns []*sessionSign
 	}{
-		Sessions:  rc.Conference.Sessions(),
-		Locations: make(map[string][]activity),
+		Kind:     rc.FormValue("kind"),
+		Location: rc.FormValue("location"),
+	}
+	if data.Kind != "session" {
+		data.Kind = "room"
+	}
+	data.Session, _ = strconv.Atoi(rc.FormValue("session"))
+	for i := 1; i <= conference.NumSession; i++ {
+		data.Sessions = append(data.Sessions, i)
 	}
 
-	ignoreLunchLocation := rc.Conference.GeneralLunch().Location
-
-	for _, l := range rc.Conference.Configuration.Lunches {
-		if l.Location == ignoreLunchLocation {
+	for location, activities := range rc.Conference.LocationActivities() {
+		if location == "" {
 			continue
 		}
-		t := conference.Seating1LunchTime
-		if l.Seating != 1 {
-			t = conference.Seating2LunchTime
+		data.Locations = append(data.Locations, location)
+		if data.Location != "" && data.Location != location {
+			continue
 		}
-		data.Locations[l.Location] = append(data.Locations[l.Location],
-			activity{Time: t, Name: fmt.Sprintf("%s Lunch", l.Name)})
+		data.Rooms = append(data.Rooms, &room{Location: location, Activities: activities})
 	}
+	sort.Strings(data.Locations)
+	sort.Slice(data.Rooms, func(i, j int) bool { return data.Rooms[i].Location < data.Rooms[j].Location })
 
-	for _, sessionClasses := range data.Sessions {
+	for i, sessionClasses := range rc.Conference.Sessions() {
+		if data.Session != 0 && data.Session != i+1 {
+			continue
+		}
+		var signs []*sessionSign
 		for _, sc := range sessionClasses {
-			t := conference.SessionTimes[sc.Session]
-			if sc.Session == conference.LunchSession {
-				if rc.Conference.ClassLunch(sc.Class).Seating == 1 {
-					t = conference.Seating1ClassTime
-				} else {
-					t = conference.Seating2ClassTime
-				}
+			if sc.Location == "" || (data.Location != "" && data.Location != sc.Location) {
+				continue
 			}
-			data.Locations[sc.Location] = append(data.Locations[sc.Location],
-				activity{Time: t, Name: fmt.Sprintf("%d: %s%s", sc.Number, sc.ShortTitle(), sc.IofN())})
+			signs = append(signs, &sessionSign{SessionClass: sc, Time: rc.Conference.SessionClassTime(sc)})
 		}
+		sort.Slice(signs, func(i, j int) bool { return signs[i].Location < signs[j].Location })
+		data.SessionSigns = append(data.SessionSigns, signs...)
 	}
 
-	for _, activities := range data.Locations {
-		sort.Slice(activities, func(i, j int) bool {
-			return activities[i].Time.Start < activities[j].Time.Start
-		})
+	return rc.Respond(s.templates.DoorSigns, http.StatusOK, &data)
+}
+
+// Serve_dashboard_kiosk shows the current and next activity in each room for
+// display on a screen at the conference. The page refreshes itself.
+func (s *service) Serve_dashboard_kiosk(rc *requestContext) error {
+	type room struct {
+		Location  string
+		Now, Next *conference.Activity
 	}
 
-	return rc.Respond(s.templates.Classrooms, http.StatusOK, &data)
+	data := struct {
+		Time  time.Time
+		Rooms []*room
+	}{
+		Time: s.Now(rc.Conference).In(conference.TimeLocation),
+	}
+
+	for location, activities := range rc.Conference.LocationActivities() {
+		if location == "" {
+			continue
+		}
+		r := &room{Location: location}
+		r.Now, r.Next = rc.Conference.ActivitiesNowNext(activities, data.Time)
+		if r.Now == nil && r.Next == nil {
+			continue
+		}
+		data.Rooms = append(data.Rooms, r)
+	}
+	sort.Slice(data.Rooms, func(i, j int) bool { return data.Rooms[i].Location < data.Rooms[j].Location })
+
+	return rc.Respond(s.templates.Kiosk, http.StatusOK, &data)
 }
 
 func evalUpdateString(source string, t time.Time) string {
@@ -530,10 +893,6 @@ func evalUpdateString(source string, t time.Time) string {
 }
 
 func (s *service) Serve_dashboard_evaluations_(rc *requestContext) error {
-	if !rc.IsStaff() {
-		return application.ErrForbidden
-	}
-
 	participant := rc.Conference.Participant(strings.TrimPrefix(rc.Request.URL.Path, "/dashboard/evaluations/"))
 	if participant == nil {
 		return application.ErrNotFound
@@ -699,9 +1058,25 @@ func (s *service) Serve_dashboard_evaluations_(rc *requestContext) error {
 	return rc.Redirect(data.Redirect, "info", "Updated evaluation for %s: %s", data.Participant.Name(), strings.Join(changes, "; "))
 }
 
+// Serve_dashboard_evalCode finds a participant by login code for staff who
+// enter paper evaluations. Failed lookups are throttled per client and per
+// staff user so that the page cannot be used to guess login codes.
 func (s *service) Serve_dashboard_evalCode(rc *requestContext) error {
-	if !rc.IsStaff() {
-		return application.ErrForbidden
+	var data struct {
+		Wait time.Duration
+	}
+	if _, ok := rc.Request.Form["loginCode"]; !ok {
+		return rc.Respond(s.templates.EvalCode, http.StatusOK, &data)
+	}
+
+	keys := append(rc.ScopedAttemptKeys("evalCode"), "staff:"+rc.StaffID)
+	wait, err := s.Throttle.Wait(rc.Ctx, keys...)
+	if err != nil {
+		return err
+	}
+	if wait > 0 {
+		data.Wait = wait.Round(time.Second)
+		return rc.Respond(s.templates.EvalCode, http.StatusTooManyRequests, &data)
 	}
 
 	loginCode := rc.FormValue("loginCode")
@@ -713,18 +1088,16 @@ func (s *service) Serve_dashboard_evalCode(rc *requestContext) error {
 		return nil
 	}
 
-	if _, ok := rc.Request.Form["loginCode"]; ok {
-		rc.MarkInputInvalid("loginCode")
+	log.Logf(rc.Ctx, log.Warning, "evaluation code lookup failed for staff %s", rc.StaffID)
+	if err := s.Throttle.Fail(rc.Ctx, "evaluation code lookup", keys...); err != nil {
+		return err
 	}
-	return rc.Respond(s.templates.EvalCode, http.StatusOK, nil)
+	rc.MarkInputInvalid("loginCode")
+	return rc.Respond(s.templates.EvalCode, http.StatusOK, &data)
 }
 
 /*
 func (s *service) Serve_dashboard_report(rc *requestContext) error {
-	if !rc.IsStaff() {
-		return application.ErrForbidden
-	}
-
 	type instructorKey struct {
 		participantID string
 		classNumber   int
@@ -937,3 +1310,50 @@ func (s *service) Serve_dashboard_report(rc *requestContext) error {
 	return rc.respond(svc.templates.Report, http.StatusOK, &data)
 }
 */
+
+func (s *service) Serve_dashboard_sitePreview(rc *requestContext) error {
+	if rc.IsPost() {
+		var t time.Time
+		if rc.FormValue("end") == "" {
+			var err error
+			t, err = time.ParseInLocation("2006-01-02T15:04", rc.FormValue("time"), conference.TimeLocation)
+			if err != nil {
+				return application.ErrBadRequest
+			}
+		}
+		rc.SetSitePreview(t)
+		if t.IsZero() {
+			return rc.Redirect("/dashboard/sitePreview", application.FlashInfo, "Preview ended.")
+		}
+		http.Redirect(rc.Response, rc.Request, "/", http.StatusSeeOther)
+		return nil
+	}
+
+	type siteTime struct {
+		Label string
+		Time  time.Time
+	}
+	sched := rc.Conference.ParticipantSiteSchedule()
+	var data = struct {
+		Schedule *conference.SiteSchedule
+		State    *conference.SiteState
+		Preview  time.Time
+		Times    []*siteTime
+	}{
+		Schedule: sched,
+		State:    rc.Conference.ParticipantSiteState(time.Now().In(conference.TimeLocation)),
+		Times: []*siteTime{
+			{"Before opening", sched.Opens().Add(-time.Minute)},
+			{"Login opens", sched.Login.Open},
+			{"Login closes", sched.Login.Close},
+			{"Evaluation closes", sched.Evaluation.Close},
+			{"Schedule closes", sched.Schedule.Close},
+		},
+	}
+	for _, t := range data.Times {
+		t.Time = t.Time.In(conference.TimeLocation)
+	}
+	data.Preview, _ = rc.SitePreview()
+	sort.SliceStable(data.Times, func(i, j int) bool { return data.Times[i].Time.Before(data.Times[j].Time) })
+	return rc.Respond(s.templates.SitePreview, http.StatusOK, &data)
+}
@@ -0,0 +1,349 @@
+package dashboard
+
+import (
+	"bytes"
+	"encoding/csv"
+	"fmt"
+	"html/template"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/seaptc/seaptc/application"
+	"github.com/seaptc/seaptc/conference"
+	"github.com/seaptc/seaptc/store"
+)
+
+// The instructor portal is reached with the number and access token of a
+// class in the c and t query parameters. The portal includes all classes
+// that share an instructor email address with that class. Staff with the
+// view permission do not need the access token.
+
+type instructorPortal struct {
+	// Class is the class for the access token.
+	Class *conference.Class
+
+	// Classes is the instructor's classes sorted by number.
+	Classes []*conference.Class
+
+	// Query is the c and t parameters for links within the portal.
+	Query template.URL
+}
+
+func (s *service) instructorPortal(rc *requestContext) (*instructorPortal, error) {
+	n, _ := strconv.Atoi(rc.FormValue("c"))
+	class := rc.Conference.Class(n)
+	if class == nil {
+		return nil, application.ErrNotFound
+	}
+	token := rc.FormValue("t")
+	if !rc.Can(conference.PermissionView) && (len(class.AccessToken) < 4 || token != class.AccessToken) {
+		return nil, application.ErrForbidden
+	}
+
+	emails := make(map[string]bool)
+	for _, e := range class.InstructorEmails {
+		emails[strings.ToLower(strings.TrimSpace(e))] = true
+	}
+
+	portal := &instructorPortal{
+		Class: class,
+		Query: template.URL(url.Values{"c": {strconv.Itoa(class.Number)}, "t": {token}}.Encode()),
+	}
+	for _, c := range rc.Conference.Classes() {
+		if c == class {
+			portal.Classes = append(portal.Classes, c)
+			continue
+		}
+		for _, e := range c.InstructorEmails {
+			if emails[strings.ToLower(strings.TrimSpace(e))] {
+				portal.Classes = append(portal.Classes, c)
+				break
+			}
+		}
+	}
+	sort.Slice(portal.Classes, func(i, j int) bool { return portal.Classes[i].Number < portal.Classes[j].Number })
+	return portal, nil
+}
+
+// portalClass returns the class in the class parameter if the class is in
+// the portal.
+func (p *instructorPortal) portalClass(rc *requestContext) (*conference.Class, error) {
+	n, _ := strconv.Atoi(rc.FormValue("class"))
+	for _, c := range p.Classes {
+		if c.Number == n {
+			return c, nil
+		}
+	}
+	return nil, application.ErrNotFound
+}
+
+// evaluationResultsOpen returns true if class evaluation results are
+// available to instructors. Results are available after the participant
+// evaluation window closes. The time when the results are available is
+// returned for display.
+func (s *service) evaluationResultsOpen(rc *requestContext) (bool, string) {
+	t := rc.Conference.ParticipantSiteSchedule().Evaluation.Close
+	return !s.Now(rc.Conference).Before(t), t.In(conference.TimeLocation).Format("Monday, January 2 at 3:04 PM")
+}
+
+func (s *service) Serve_dashboard_instructor(rc *requestContext) error {
+	portal, err := s.instructorPortal(rc)
+	if err != nil {
+		return err
+	}
+
+	handouts, err := s.Store.GetHandouts(rc.Ctx)
+	if err != nil {
+		return err
+	}
+
+	type portalClass struct {
+		*conference.Class
+		Participants int
+		Lunch        *conference.Lunch
+		Handouts     []*store.Handout
+		Results      []*conference.SessionResults
+	}
+
+	data := struct {
+		*instructorPortal
+		Classes        []*portalClass
+		ResultsOpen    bool
+		ResultsOpenAt  string
+		MinEvaluations int
+		MaxHandoutSize int
+	}{
+		instructorPortal: portal,
+		MinEvaluations:   conference.MinResultEvaluations,
+		MaxHandoutSize:   store.MaxHandoutSize,
+	}
+
+	data.ResultsOpen, data.ResultsOpenAt = s.evaluationResultsOpen(rc)
+
+	var evals map[string]*conference.Evaluation
+	if data.ResultsOpen {
+		evals, err = s.Store.GetEvaluations(rc.Ctx)
+		if err != nil {
+			return err
+		}
+	}
+
+	for _, c := range portal.Classes {
+		pc := &portalClass{
+			Class:        c,
+			Participants: len(rc.Conference.ClassParticipants(c)),
+			Lunch:        rc.Conference.ClassLunch(c),
+			Handouts:     handouts[c.Number],
+		}
+		if data.ResultsOpen {
+			pc.Results = rc.Conference.ClassEvaluationResults(c, evals)
+		}
+		data.Classes = append(data.Classes, pc)
+	}
+
+	return rc.Respond(s.templates.Instructor, http.StatusOK, &data)
+}
+
+func (s *service) Serve_dashboard_instructor_roster(rc *requestContext) error {
+	portal, err := s.instructorPortal(rc)
+	if err != nil {
+		return err
+	}
+	class, err := portal.portalClass(rc)
+	if err != nil {
+		return err
+	}
+
+	participants := rc.Conference.ClassParticipants(class)
+	conference.SortParticipants(participants, "name")
+
+	var buf bytes.Buffer
+	w := csv.NewWriter(&buf)
+	w.Write([]string{"Last Name", "First Name", "Nickname", "Type", "Council", "District", "Unit", "Email"})
+	for _, p := range participants {
+		w.Write([]string{p.LastName, p.FirstName, p.Nickname, p.Type(), p.Council, p.District, p.Unit(), p.Email})
+	}
+	w.Flush()
+	if err := w.Error(); err != nil {
+		return err
+	}
+	rc.RespondAttachment(fmt.Sprintf("roster-%d.csv", class.Number), "text/csv; charset=utf-8", buf.Bytes())
+	return nil
+}
+
+func (s *service) Serve_dashboard_instructor_signIn(rc *requestContext) error {
+	portal, err := s.instructorPortal(rc)
+	if err != nil {
+		return err
+	}
+	class, err := portal.portalClass(rc)
+	if err != nil {
+		return err
+	}
+
+	participants := rc.Conference.ClassParticipants(class)
+	conference.SortParticipants(participants, "name")
+
+	var data = struct {
+		Sessions     []*conference.SessionClass
+		Participants []*conference.Participant
+		Time         func(*conference.SessionClass) *conference.ScheduleTime
+	}{
+		Participants: participants,
+		Time:         rc.Conference.SessionClassTime,
+	}
+	for i := class.Start; i <= class.End; i++ {
+		data.Sessions = append(data.Sessions, &conference.SessionClass{Class: class, Session: i})
+	}
+	return rc.Respond(s.templates.SignIn, http.StatusOK, &data)
+}
+
+// handoutName returns the base name of an uploaded file. Browsers on Windows
+// may send the full path of the file. Characters with special meaning in a
+// URL are replaced so that the name can be used in the handout path.
+func handoutName(filename string) string {
+	if i := strings.LastIndexAny(filename, `/\`); i >= 0 {
+		filename = filename[i+1:]
+	}
+	return strings.TrimSpace(strings.NewReplacer("?", "_", "#", "_", "%", "_").Replace(filename))
+}
+
+func (s *service) Serve_dashboard_instructor_uploadHandout(rc *requestContext) error {
+	if !rc.IsPost() {
+		return application.ErrBadRequest
+	}
+	portal, err := s.instructorPortal(rc)
+	if err != nil {
+		return err
+	}
+	// The class is in the action URL query. Form fields in a multipart body
+	// are not available until the body is parsed.
+	class, err := portal.portalClass(rc)
+	if err != nil {
+		return err
+	}
+
+	f, header, err := rc.Request.FormFile("file")
+	if err == http.ErrMissingFile {
+		return &application.HTTPError{Status: http.StatusBadRequest, Message: "File is required."}
+	} else if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	name := handoutName(header.Filename)
+	if name == "" {
+		return &application.HTTPError{Status: http.StatusBadRequest, Message: "File name is required."}
+	}
+	if header.Size > store.MaxHandoutSize {
+		return rc.Redirect("/dashboard/instructor?"+string(portal.Query), application.FlashError,
+			"%s is too large. Handouts are limited to %d KB. Post larger files elsewhere and share the link with participants.",
+			name, store.MaxHandoutSize/1000)
+	}
+	data, err := ioutil.ReadAll(f)
+	if err != nil {
+		return err
+	}
+
+	contentType := header.Header.Get("Content-Type")
+	if contentType == "" {
+		contentType = "application/octet-stream"
+	}
+
+	ctx := rc.Ctx
+	if rc.StaffID == "" {
+		ctx = store.WithActor(ctx, fmt.Sprintf("instructor:%d", portal.Class.Number))
+	}
+	if err := s.Store.PutHandout(ctx, &store.Handout{Class: class.Number, Name: name, ContentType: contentType}, data); err != nil {
+		return err
+	}
+	return rc.Redirect("/dashboard/instructor?"+string(portal.Query), application.FlashInfo, "Uploaded %s for class %d.", name, class.Number)
+}
+
+func (s *service) Serve_dashboard_instructor_deleteHandout(rc *requestContext) error {
+	if !rc.IsPost() {
+		return application.ErrBadRequest
+	}
+	portal, err := s.instructorPortal(rc)
+	if err != nil {
+		return err
+	}
+	class, err := portal.portalClass(rc)
+	if err != nil {
+		return err
+	}
+	name := rc.FormValue("name")
+
+	ctx := rc.Ctx
+	if rc.StaffID == "" {
+		ctx = store.WithActor(ctx, fmt.Sprintf("instructor:%d", portal.Class.Number))
+	}
+	if err := s.Store.DeleteHandout(ctx, class.Number, name); err != nil {
+		return err
+	}
+	return rc.Redirect("/dashboard/instructor?"+string(portal.Query), application.FlashInfo, "Deleted %s from class %d.", name, class.Number)
+}
+
+func (s *service) Serve_dashboard_instructor_handout(rc *requestContext) error {
+	portal, err := s.instructorPortal(rc)
+	if err != nil {
+		return err
+	}
+	class, err := portal.portalClass(rc)
+	if err != nil {
+		return err
+	}
+	h, data, err := s.Store.GetHandout(rc.Ctx, class.Number, rc.FormValue("name"))
+	if err != nil {
+		return err
+	}
+	if h == nil {
+		return application.ErrNotFound
+	}
+	rc.RespondAttachment(h.Name, h.ServeContentType(), data)
+	return nil
+}
+
+// Serve_dashboard_classResults_ shows the evaluation results for a class to
+// instructors with the class access token and to staff with the evaluations
+// permission.
+func (s *service) Serve_dashboard_classResults_(rc *requestContext) error {
+	n, _ := strconv.Atoi(strings.TrimPrefix(rc.Request.URL.Path, "/dashboard/classResults/"))
+	class := rc.Conference.Class(n)
+	if class == nil {
+		return application.ErrNotFound
+	}
+
+	staff := rc.Can(conference.PermissionEvaluations)
+	if !staff && (len(class.AccessToken) < 4 || rc.FormValue("t") != class.AccessToken) {
+		return application.ErrForbidden
+	}
+
+	data := struct {
+		Class          *conference.Class
+		Registered     int
+		ResultsOpen    bool
+		ResultsOpenAt  string
+		MinEvaluations int
+		Sessions       []*conference.SessionResults
+	}{
+		Class:          class,
+		Registered:     len(rc.Conference.ClassParticipants(class)),
+		MinEvaluations: conference.MinResultEvaluations,
+	}
+	data.ResultsOpen, data.ResultsOpenAt = s.evaluationResultsOpen(rc)
+	data.ResultsOpen = data.ResultsOpen || staff
+
+	if data.ResultsOpen {
+		evals, err := s.Store.GetEvaluations(rc.Ctx)
+		if err != nil {
+			return err
+		}
+		data.Sessions = rc.Conference.ClassEvaluationResults(class, evals)
+	}
+	return rc.Respond(s.templates.ClassResults, http.StatusOK, &data)
+}
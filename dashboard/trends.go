@@ -0,0 +1,136 @@
+package dashboard
+
+import (
+	"net/http"
+	"sort"
+	"strconv"
+
+	"github.com/seaptc/seaptc/application"
+	"github.com/seaptc/seaptc/conference"
+	"github.com/seaptc/seaptc/store"
+)
+
+// Serve_dashboard_trends compares classes and instructors across conference
+// years. Previous years are summaries stored with the archive and upload
+// handlers below. The current year is computed from the conference.
+func (s *service) Serve_dashboard_trends(rc *requestContext) error {
+	stored, err := s.Store.GetClassYears(rc.Ctx)
+	if err != nil {
+		return err
+	}
+	evals, err := s.Store.GetEvaluations(rc.Ctx)
+	if err != nil {
+		return err
+	}
+
+	currentYear := rc.Conference.Date.Year()
+	classYears := rc.Conference.ClassYears(evals)
+	years := map[int]bool{currentYear: true}
+	archived := make(map[int]bool)
+	for _, cy := range stored {
+		archived[cy.Year] = true
+		if cy.Year != currentYear {
+			classYears = append(classYears, cy)
+			years[cy.Year] = true
+		}
+	}
+
+	data := struct {
+		View        string
+		Multi       bool
+		Years       []int
+		Archived    []int
+		CurrentYear int
+		Trends      []*conference.Trend
+	}{
+		View:        rc.FormValue("view"),
+		Multi:       rc.FormValue("multi") != "",
+		CurrentYear: currentYear,
+	}
+	for y := range years {
+		data.Years = append(data.Years, y)
+	}
+	sort.Ints(data.Years)
+	for y := range archived {
+		data.Archived = append(data.Archived, y)
+	}
+	sort.Ints(data.Archived)
+
+	var trends []*conference.Trend
+	if data.View == "instructors" {
+		trends = conference.InstructorTrends(classYears)
+	} else {
+		data.View = "series"
+		trends = conference.SeriesTrends(classYears)
+	}
+	for _, t := range trends {
+		if data.Multi && len(t.Years) < 2 {
+			continue
+		}
+		data.Trends = append(data.Trends, t)
+	}
+
+	return rc.Respond(s.templates.Trends, http.StatusOK, &data)
+}
+
+// Serve_dashboard_archiveClassYears stores the class summaries for the
+// current year. Archive the year before loading the next year's classes.
+func (s *service) Serve_dashboard_archiveClassYears(rc *requestContext) error {
+	if !rc.IsPost() {
+		return application.ErrBadRequest
+	}
+	evals, err := s.Store.GetEvaluations(rc.Ctx)
+	if err != nil {
+		return err
+	}
+	year := rc.Conference.Date.Year()
+	classYears := rc.Conference.ClassYears(evals)
+	if err := s.Store.PutClassYears(rc.Ctx, year, classYears); err != nil {
+		return err
+	}
+	return rc.Redirect("/dashboard/trends", application.FlashInfo, "Archived %d classes for %d.", len(classYears), year)
+}
+
+// Serve_dashboard_uploadClassYears stores the class summaries from the backup
+// archive of a previous year.
+func (s *service) Serve_dashboard_uploadClassYears(rc *requestContext) error {
+	if !rc.IsPost() {
+		return application.ErrBadRequest
+	}
+
+	f, _, err := rc.Request.FormFile("file")
+	if err == http.ErrMissingFile {
+		return &application.HTTPError{Status: http.StatusBadRequest, Message: "File is required."}
+	} else if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	conf, evals, err := store.ConferenceFromBackup(f)
+	if err != nil {
+		return &application.HTTPError{Status: http.StatusBadRequest, Message: err.Error(), Err: err}
+	}
+	if conf.Date.IsZero() {
+		return &application.HTTPError{Status: http.StatusBadRequest, Message: "The backup does not have a conference date."}
+	}
+	year := conf.Date.Year()
+	classYears := conf.ClassYears(evals)
+	if err := s.Store.PutClassYears(rc.Ctx, year, classYears); err != nil {
+		return err
+	}
+	return rc.Redirect("/dashboard/trends", application.FlashInfo, "Loaded %d classes for %d.", len(classYears), year)
+}
+
+func (s *service) Serve_dashboard_deleteClassYears(rc *requestContext) error {
+	if !rc.IsPost() {
+		return application.ErrBadRequest
+	}
+	year, err := strconv.Atoi(rc.FormValue("year"))
+	if err != nil {
+		return application.ErrBadRequest
+	}
+	if err := s.Store.DeleteClassYears(rc.Ctx, year); err != nil {
+		return err
+	}
+	return rc.Redirect("/dashboard/trends", application.FlashInfo, "Deleted class summaries for %d.", year)
+}
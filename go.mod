@@ -4,11 +4,12 @@ go 1.13
 
 require (
 	cloud.google.com/go v0.38.0
+	github.com/golang/protobuf v1.3.3
 	go.opencensus.io v0.22.3 // indirect
 	golang.org/x/net v0.0.0-20191002035440-2ec189313ef0 // indirect
 	golang.org/x/oauth2 v0.0.0-20200107190931-bf48bf16ab8d
-	google.golang.org/api v0.20.0 // indirect
-	google.golang.org/genproto v0.0.0-20200303153909-beee998c1893 // indirect
+	google.golang.org/api v0.20.0
+	google.golang.org/genproto v0.0.0-20200303153909-beee998c1893
 	google.golang.org/grpc v1.27.1 // indirect
 	rsc.io/qr v0.2.0
 )
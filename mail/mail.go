@@ -0,0 +1,136 @@
+// Package mail sends email messages.
+package mail
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io/ioutil"
+	"mime"
+	netmail "net/mail"
+	"net/smtp"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/seaptc/seaptc/log"
+)
+
+// Message is a plain text email message.
+type Message struct {
+	To      []string
+	Subject string
+	Body    string
+
+	// Headers are additional headers, for example List-Unsubscribe.
+	Headers map[string]string
+}
+
+// Sender sends messages.
+type Sender interface {
+	Send(ctx context.Context, m *Message) error
+}
+
+// Open returns a sender for spec:
+//
+//	log                                     log messages
+//	dir:{path}                              write messages to files in path
+//	smtp://{user}:{password}@{host}:{port}  send messages with SMTP
+//
+// An empty spec is the same as "log". Omit the user and password to send to
+// a local test server, for example smtp://localhost:1025. From is the sender
+// address.
+func Open(spec string, from string) (Sender, error) {
+	switch {
+	case spec == "" || spec == "log":
+		return &Log{From: from}, nil
+	case strings.HasPrefix(spec, "dir:"):
+		return &Dir{Path: strings.TrimPrefix(spec, "dir:"), From: from}, nil
+	case strings.HasPrefix(spec, "smtp:"):
+		u, err := url.Parse(spec)
+		if err != nil {
+			return nil, fmt.Errorf("mail: bad SMTP spec: %w", err)
+		}
+		password, _ := u.User.Password()
+		return &SMTP{Addr: u.Host, Username: u.User.Username(), Password: password, From: from}, nil
+	default:
+		return nil, fmt.Errorf("mail: unknown sender %q", spec)
+	}
+}
+
+// format returns the message in RFC 5322 format.
+func format(from string, m *Message) []byte {
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "From: %s\r\n", from)
+	fmt.Fprintf(&buf, "To: %s\r\n", strings.Join(m.To, ", "))
+	fmt.Fprintf(&buf, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", m.Subject))
+	fmt.Fprintf(&buf, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	names := make([]string, 0, len(m.Headers))
+	for name := range m.Headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		fmt.Fprintf(&buf, "%s: %s\r\n", name, m.Headers[name])
+	}
+	buf.WriteString("MIME-Version: 1.0\r\n")
+	buf.WriteString("Content-Type: text/plain; charset=utf-8\r\n")
+	buf.WriteString("Content-Transfer-Encoding: 8bit\r\n")
+	buf.WriteString("\r\n")
+	buf.WriteString(strings.ReplaceAll(m.Body, "\n", "\r\n"))
+	return buf.Bytes()
+}
+
+// SMTP sends messages through an SMTP server.
+type SMTP struct {
+	Addr     string // host:port
+	Username string // no authentication if empty
+	Password string
+	From     string
+}
+
+func (s *SMTP) Send(ctx context.Context, m *Message) error {
+	var auth smtp.Auth
+	if s.Username != "" {
+		host := s.Addr
+		if i := strings.LastIndexByte(host, ':'); i >= 0 {
+			host = host[:i]
+		}
+		auth = smtp.PlainAuth("", s.Username, s.Password, host)
+	}
+	from, err := netmail.ParseAddress(s.From)
+	if err != nil {
+		return fmt.Errorf("mail: bad from address: %w", err)
+	}
+	return smtp.SendMail(s.Addr, auth, from.Address, m.To, format(s.From, m))
+}
+
+// Log logs messages for development.
+type Log struct {
+	From string
+}
+
+func (l *Log) Send(ctx context.Context, m *Message) error {
+	log.Logf(ctx, log.Info, "mail:\n%s", format(l.From, m))
+	return nil
+}
+
+// Dir writes each message to a file in a directory for tests.
+type Dir struct {
+	Path string
+	From string
+}
+
+var unsafeFileChars = regexp.MustCompile(`[^A-Za-z0-9@._-]+`)
+
+func (d *Dir) Send(ctx context.Context, m *Message) error {
+	if err := os.MkdirAll(d.Path, 0777); err != nil {
+		return err
+	}
+	name := fmt.Sprintf("%d-%s.eml", time.Now().UnixNano(), unsafeFileChars.ReplaceAllString(strings.Join(m.To, ","), "_"))
+	return ioutil.WriteFile(filepath.Join(d.Path, name), format(d.From, m), 0666)
+}
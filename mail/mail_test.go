@@ -0,0 +1,91 @@
+package mail
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestOpen(t *testing.T) {
+	for _, tt := range []struct {
+		spec string
+		want interface{}
+	}{
+		{"", &Log{}},
+		{"log", &Log{}},
+		{"dir:/tmp/mail", &Dir{}},
+		{"smtp://user:pw@localhost:1025", &SMTP{}},
+	} {
+		s, err := Open(tt.spec, "PTC <noreply@example.com>")
+		if err != nil {
+			t.Errorf("Open(%q) returned error %v", tt.spec, err)
+			continue
+		}
+		switch tt.want.(type) {
+		case *Log:
+			_, ok := s.(*Log)
+			if !ok {
+				t.Errorf("Open(%q) = %T, want *Log", tt.spec, s)
+			}
+		case *Dir:
+			d, ok := s.(*Dir)
+			if !ok || d.Path != "/tmp/mail" {
+				t.Errorf("Open(%q) = %#v, want *Dir with path /tmp/mail", tt.spec, s)
+			}
+		case *SMTP:
+			m, ok := s.(*SMTP)
+			if !ok || m.Addr != "localhost:1025" || m.Username != "user" || m.Password != "pw" {
+				t.Errorf("Open(%q) = %#v, want *SMTP for user:pw@localhost:1025", tt.spec, s)
+			}
+		}
+	}
+
+	if _, err := Open("carrier-pigeon", ""); err == nil {
+		t.Error("Open with unknown sender did not return error")
+	}
+}
+
+func TestDir(t *testing.T) {
+	dir, err := ioutil.TempDir("", "mail")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	d := &Dir{Path: filepath.Join(dir, "out"), From: "PTC <noreply@example.com>"}
+	err = d.Send(context.Background(), &Message{
+		To:      []string{"a@example.com"},
+		Subject: "Login link",
+		Body:    "Hello\nThere",
+		Headers: map[string]string{"List-Unsubscribe": "<https://example.com/unsubscribe>"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	files, err := filepath.Glob(filepath.Join(d.Path, "*-a@example.com.eml"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 1 {
+		t.Fatalf("found files %v, want one message for a@example.com", files)
+	}
+	p, err := ioutil.ReadFile(files[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := string(p)
+	for _, want := range []string{
+		"From: PTC <noreply@example.com>\r\n",
+		"To: a@example.com\r\n",
+		"Subject: Login link\r\n",
+		"List-Unsubscribe: <https://example.com/unsubscribe>\r\n",
+		"\r\n\r\nHello\r\nThere",
+	} {
+		if !strings.Contains(got, want) {
+			t.Errorf("message does not contain %q:\n%s", want, got)
+		}
+	}
+}
@@ -11,6 +11,8 @@ import (
 	"github.com/seaptc/seaptc/application"
 	"github.com/seaptc/seaptc/catalog"
 	"github.com/seaptc/seaptc/dashboard"
+	"github.com/seaptc/seaptc/mail"
+	"github.com/seaptc/seaptc/notify"
 	"github.com/seaptc/seaptc/participant"
 	"github.com/seaptc/seaptc/store"
 )
@@ -28,20 +30,44 @@ func main() {
 	devMode := os.Getenv("GAE_INSTANCE") == ""
 
 	var (
-		addr         = flag.String("addr", defaultAddr, "Listen on this address")
-		projectID    = flag.String("p", store.DefaultProjectID(), "Project id")
-		assetsDir    = flag.String("d", "assets", "Direcory containing assets")
-		useEmulator  = flag.Bool("e", devMode, "Use Datastore emulator")
-		timeOverride = flag.Duration("t", 0, "Use current time as conference date plus this duration")
+		addr          = flag.String("addr", defaultAddr, "Listen on this address")
+		projectID     = flag.String("p", store.DefaultProjectID(), "Project id")
+		assetsDir     = flag.String("d", "assets", "Direcory containing assets")
+		useEmulator   = flag.Bool("e", devMode, "Use Datastore emulator")
+		timeOverride  = flag.Duration("t", 0, "Use current time as conference date plus this duration")
+		notifyTopic   = flag.String("notify", os.Getenv("NOTIFY_TOPIC"), "Pub/Sub topic for data change notifications, in-process notifications if empty")
+		storeAttempts = flag.Bool("storeAttempts", os.Getenv("STORE_LOGIN_ATTEMPTS") != "", "Track failed login attempts in the store instead of memory")
+		mailSpec      = flag.String("mail", "", "Send mail with log, dir:{path} or smtp://{user}:{password}@{host}:{port} instead of the configured sender")
+		mailFrom      = flag.String("mailFrom", envOr("MAIL_FROM", "PTC <noreply@seaptc.org>"), "Mail from address")
 	)
 	flag.Parse()
 	ctx := context.Background()
 
+	var notifier notify.Notifier = notify.NewLocal()
+	if *notifyTopic != "" {
+		var err error
+		notifier, err = notify.NewPubSub(ctx, *projectID, *notifyTopic)
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	// In production, the mail sender is read from the conference
+	// configuration. Mail is logged in development.
+	var mailer mail.Sender
+	if *mailSpec != "" || devMode {
+		var err error
+		mailer, err = mail.Open(*mailSpec, *mailFrom)
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
+
 	mux := http.NewServeMux()
 	mux.Handle("/static/", http.FileServer(http.Dir(*assetsDir)))
 
 	h, err := application.New(ctx,
-		*projectID, *useEmulator, *assetsDir, devMode, *timeOverride,
+		*projectID, *useEmulator, *assetsDir, devMode, *timeOverride, notifier, *storeAttempts, mailer, *mailFrom,
 		[]application.Service{
 			dashboard.New(),
 			catalog.New(),
@@ -55,3 +81,10 @@ func main() {
 	log.Printf("Listening on addr %s", *addr)
 	log.Fatal(http.ListenAndServe(*addr, h))
 }
+
+func envOr(name string, value string) string {
+	if v := os.Getenv(name); v != "" {
+		return v
+	}
+	return value
+}
@@ -0,0 +1,53 @@
+// Package notify announces changes to the conference data so that all
+// instances of the application can refresh their copy of the data.
+package notify
+
+import (
+	"context"
+	"sync"
+)
+
+// Notifier publishes and receives data versions. The version is the store's
+// meta version after a write.
+type Notifier interface {
+	// Publish announces that data with the given version was written.
+	Publish(ctx context.Context, version int64) error
+
+	// Subscribe arranges for fn to be called with each published version
+	// until ctx is done. Subscribe does not block. Versions may be
+	// delivered more than once and out of order.
+	Subscribe(ctx context.Context, fn func(version int64)) error
+}
+
+// Local is an in-process Notifier for tests and development.
+type Local struct {
+	mu   sync.Mutex
+	subs map[*func(int64)]struct{}
+}
+
+func NewLocal() *Local {
+	return &Local{subs: make(map[*func(int64)]struct{})}
+}
+
+func (n *Local) Publish(ctx context.Context, version int64) error {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	for fn := range n.subs {
+		go (*fn)(version)
+	}
+	return nil
+}
+
+func (n *Local) Subscribe(ctx context.Context, fn func(version int64)) error {
+	key := &fn
+	n.mu.Lock()
+	n.subs[key] = struct{}{}
+	n.mu.Unlock()
+	go func() {
+		<-ctx.Done()
+		n.mu.Lock()
+		delete(n.subs, key)
+		n.mu.Unlock()
+	}()
+	return nil
+}
@@ -0,0 +1,71 @@
+package notify
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestLocal(t *testing.T) {
+	n := NewLocal()
+	ctx := context.Background()
+
+	ctx1, cancel1 := context.WithCancel(ctx)
+	ch1 := make(chan int64, 10)
+	if err := n.Subscribe(ctx1, func(v int64) { ch1 <- v }); err != nil {
+		t.Fatal(err)
+	}
+	ctx2, cancel2 := context.WithCancel(ctx)
+	defer cancel2()
+	ch2 := make(chan int64, 10)
+	if err := n.Subscribe(ctx2, func(v int64) { ch2 <- v }); err != nil {
+		t.Fatal(err)
+	}
+
+	receive := func(ch chan int64) int64 {
+		select {
+		case v := <-ch:
+			return v
+		case <-time.After(time.Second):
+			t.Fatal("timeout waiting for notification")
+			return 0
+		}
+	}
+
+	if err := n.Publish(ctx, 1); err != nil {
+		t.Fatal(err)
+	}
+	if v := receive(ch1); v != 1 {
+		t.Errorf("subscriber 1 received %d, want 1", v)
+	}
+	if v := receive(ch2); v != 1 {
+		t.Errorf("subscriber 2 received %d, want 1", v)
+	}
+
+	// Wait for the subscription to be removed after cancel.
+	cancel1()
+	for i := 0; ; i++ {
+		n.mu.Lock()
+		count := len(n.subs)
+		n.mu.Unlock()
+		if count == 1 {
+			break
+		}
+		if i > 100 {
+			t.Fatalf("found %d subscriptions after cancel, want 1", count)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	if err := n.Publish(ctx, 2); err != nil {
+		t.Fatal(err)
+	}
+	if v := receive(ch2); v != 2 {
+		t.Errorf("subscriber 2 received %d, want 2", v)
+	}
+	select {
+	case v := <-ch1:
+		t.Errorf("canceled subscriber received %d", v)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
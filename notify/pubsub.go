@@ -0,0 +1,122 @@
+package notify
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"cloud.google.com/go/pubsub"
+	vkit "cloud.google.com/go/pubsub/apiv1"
+	"github.com/golang/protobuf/ptypes"
+	"github.com/seaptc/seaptc/log"
+	pb "google.golang.org/genproto/googleapis/pubsub/v1"
+)
+
+// subscriptionExpiration is the time after which Pub/Sub deletes an unused
+// subscription. One day is the minimum allowed by Pub/Sub.
+const subscriptionExpiration = 24 * time.Hour
+
+// PubSub is a Notifier using a Cloud Pub/Sub topic. Each instance receives
+// messages through its own subscription. The subscription is deleted when
+// the context passed to Subscribe is done. Subscriptions of instances that
+// stop without deleting the subscription expire after
+// subscriptionExpiration.
+type PubSub struct {
+	projectID string
+	client    *pubsub.Client
+	topic     *pubsub.Topic
+}
+
+// NewPubSub returns a notifier for the given topic. The topic is created if
+// it does not exist.
+func NewPubSub(ctx context.Context, projectID string, topicID string) (*PubSub, error) {
+	client, err := pubsub.NewClient(ctx, projectID)
+	if err != nil {
+		return nil, err
+	}
+	topic := client.Topic(topicID)
+	ok, err := topic.Exists(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		topic, err = client.CreateTopic(ctx, topicID)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return &PubSub{projectID: projectID, client: client, topic: topic}, nil
+}
+
+func (n *PubSub) Publish(ctx context.Context, version int64) error {
+	result := n.topic.Publish(ctx, &pubsub.Message{Data: []byte(strconv.FormatInt(version, 10))})
+	_, err := result.Get(ctx)
+	return err
+}
+
+func (n *PubSub) Subscribe(ctx context.Context, fn func(version int64)) error {
+	instance := os.Getenv("GAE_INSTANCE")
+	if instance == "" {
+		instance, _ = os.Hostname()
+	}
+	// Subscription names are limited to 255 letters, digits and a few
+	// punctuation characters.
+	instance = strings.Map(func(r rune) rune {
+		if ('a' <= r && r <= 'z') || ('A' <= r && r <= 'Z') || ('0' <= r && r <= '9') {
+			return r
+		}
+		return -1
+	}, instance)
+	if len(instance) > 100 {
+		instance = instance[:100]
+	}
+	id := fmt.Sprintf("%s-%s-%d", n.topic.ID(), instance, time.Now().UnixNano())
+
+	if err := n.createSubscription(ctx, id); err != nil {
+		return err
+	}
+	sub := n.client.Subscription(id)
+
+	go func() {
+		err := sub.Receive(ctx, func(ctx context.Context, m *pubsub.Message) {
+			m.Ack()
+			version, err := strconv.ParseInt(string(m.Data), 10, 64)
+			if err != nil {
+				log.Logf(ctx, log.Error, "bad notification %q", m.Data)
+				return
+			}
+			fn(version)
+		})
+		if err != nil {
+			log.Logf(ctx, log.Error, "notification subscription %s: %v", id, err)
+		}
+		// Use a new context because ctx is done.
+		if err := sub.Delete(context.Background()); err != nil {
+			log.Logf(ctx, log.Error, "delete notification subscription %s: %v", id, err)
+		}
+	}()
+	return nil
+}
+
+// createSubscription creates a subscription to the topic with an expiration
+// policy. The pubsub package in the version of cloud.google.com/go used here
+// does not support expiration policies, so the subscription is created with
+// the lower level API.
+func (n *PubSub) createSubscription(ctx context.Context, id string) error {
+	client, err := vkit.NewSubscriberClient(ctx)
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+	_, err = client.CreateSubscription(ctx, &pb.Subscription{
+		Name:                     fmt.Sprintf("projects/%s/subscriptions/%s", n.projectID, id),
+		Topic:                    n.topic.String(),
+		AckDeadlineSeconds:       10,
+		MessageRetentionDuration: ptypes.DurationProto(10 * time.Minute),
+		ExpirationPolicy:         &pb.ExpirationPolicy{Ttl: ptypes.DurationProto(subscriptionExpiration)},
+	})
+	return err
+}
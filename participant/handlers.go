@@ -1,24 +1,31 @@
 package participant
 
 import (
+	"errors"
+	"fmt"
 	"html/template"
 	"net/http"
 	"sort"
 	"strconv"
+	"strings"
 	"time"
 
 	"github.com/seaptc/seaptc/application"
 	"github.com/seaptc/seaptc/conference"
 	"github.com/seaptc/seaptc/log"
+	"github.com/seaptc/seaptc/store"
 )
 
 type templates struct {
 	After,
 	Before,
+	ChangeClass,
 	Eval1,
 	Eval2,
 	Home,
 	Login,
+	LoginLink,
+	Unsubscribe,
 	Error *template.Template `template:".,root.html,../common.html"`
 }
 
@@ -29,9 +36,9 @@ func (s *service) Serve_(rc *requestContext) error {
 		return application.ErrNotFound
 	case rc.Participant != nil:
 		return s.serveHome(rc)
-	case rc.state == stateLoginEdit:
+	case rc.Site.Login:
 		return rc.Respond(s.templates.Login, http.StatusOK, nil)
-	case rc.state == stateBefore:
+	case rc.Site.Before:
 		return rc.Respond(s.templates.Before, http.StatusOK, nil)
 	default:
 		return rc.Respond(s.templates.After, http.StatusOK, nil)
@@ -39,18 +46,40 @@ func (s *service) Serve_(rc *requestContext) error {
 }
 
 func (s *service) Serve_login(rc *requestContext) error {
+	if !rc.Site.Login {
+		http.Redirect(rc.Response, rc.Request, "/", http.StatusSeeOther)
+		return nil
+	}
+	var data struct {
+		Wait time.Duration
+	}
 	if rc.IsPost() {
+		keys := rc.AttemptKeys()
+		wait, err := s.Throttle.Wait(rc.Ctx, keys...)
+		if err != nil {
+			return err
+		}
+		if wait > 0 {
+			data.Wait = wait.Round(time.Second)
+			return rc.Respond(s.templates.Login, http.StatusTooManyRequests, &data)
+		}
 		p := rc.Conference.ParticipantFromLoginCode(rc.FormValue("loginCode"))
 		if p == nil {
 			rc.MarkInputInvalid("loginCode")
+			if err := s.Throttle.Fail(rc.Ctx, "participant login", keys...); err != nil {
+				return err
+			}
 		}
 		if !rc.HasInvalidInput() {
+			if err := s.Throttle.ResetClient(rc.Ctx, keys...); err != nil {
+				return err
+			}
 			rc.setParticipantID(s.Protocol, p.ID)
 			http.Redirect(rc.Response, rc.Request, "/", http.StatusSeeOther)
 			return nil
 		}
 	}
-	return rc.Respond(s.templates.Login, http.StatusOK, nil)
+	return rc.Respond(s.templates.Login, http.StatusOK, &data)
 }
 
 func (s *service) Serve_logout(rc *requestContext) error {
@@ -81,21 +110,30 @@ func (s *service) serveHome(rc *requestContext) error {
 	}
 	sort.Slice(evaluatedClasses, func(i, j int) bool { return evaluatedClasses[i].Session < evaluatedClasses[j].Session })
 
+	handouts, err := s.Store.GetHandouts(rc.Ctx)
+	if err != nil {
+		return err
+	}
+
 	data := struct {
 		Schedule            []*conference.ScheduleItem
+		Now, Next           *conference.ScheduleItem
+		Handouts            map[int][]*store.Handout
 		EvaluatedClasses    []*conference.SessionClass
 		EvaluatedConference bool
 	}{
 		Schedule:            rc.Conference.ParticipantSchedule(rc.Participant),
+		Handouts:            handouts,
 		EvaluatedClasses:    evaluatedClasses,
 		EvaluatedConference: eval.Conference != nil,
 	}
+	data.Now, data.Next = rc.Conference.ScheduleNowNext(data.Schedule, rc.Site.Time)
 
 	return rc.Respond(s.templates.Home, http.StatusOK, &data)
 }
 
 func (s *service) Serve_eval(rc *requestContext) error {
-	if rc.Participant == nil {
+	if rc.Participant == nil || !rc.Site.Evaluation {
 		http.Redirect(rc.Response, rc.Request, "/", http.StatusSeeOther)
 		return nil
 	}
@@ -109,12 +147,31 @@ func (s *service) Serve_eval(rc *requestContext) error {
 		EvaluateConference   bool
 		EvaluateSession      bool
 		IsInstructor         bool
+
+		// Offline is true for the session evaluation form that the
+		// service worker shows for evaluation codes when the network is
+		// not available.
+		Offline bool
+
+		// Hashes of the stored evaluations when the form was rendered.
+		BaseHash, BaseHashc string
+
+		// Conflict is true when the stored evaluation changed after the
+		// form was rendered.
+		Conflict bool
 	}{
 		EvaluateConference:   evalCode == "conference",
 		SessionEvaluation:    &conference.SessionEvaluation{},
 		ConferenceEvaluation: &conference.ConferenceEvaluation{},
 	}
 
+	if rc.FormValue("offline") != "" && !rc.IsPost() {
+		data.Offline = true
+		data.EvaluateSession = true
+		data.BaseHash = data.SessionEvaluation.Hash()
+		return rc.Respond(s.templates.Eval2, http.StatusOK, &data)
+	}
+
 	if !data.EvaluateConference {
 		data.SessionClass = rc.Conference.SessionClassFromEvaluationCode(evalCode)
 		if data.SessionClass == nil {
@@ -124,7 +181,8 @@ func (s *service) Serve_eval(rc *requestContext) error {
 			return rc.Respond(s.templates.Eval1, http.StatusOK, &data)
 		}
 		data.EvaluateSession = true
-		data.EvaluateConference = data.SessionClass.Session == conference.NumSession-1
+		// The offline form does not include the conference evaluation.
+		data.EvaluateConference = data.SessionClass.Session == conference.NumSession-1 && rc.FormValue("offline") == ""
 
 		sessionClasses := rc.Conference.ParticipantSessionClasses(rc.Participant)
 		if sessionClasses[data.SessionClass.Session].Number == data.SessionClass.Number &&
@@ -133,23 +191,31 @@ func (s *service) Serve_eval(rc *requestContext) error {
 		}
 	}
 
-	if !rc.IsPost() {
-		eval, err := s.Store.GetEvaluation(rc.Ctx, rc.Participant.ID)
-		if err != nil {
-			return err
-		}
-
-		if data.EvaluateSession {
-			for _, se := range eval.Sessions {
-				if se.Session == data.SessionClass.Session {
-					data.SessionEvaluation = se
-					break
-				}
+	stored, err := s.Store.GetEvaluation(rc.Ctx, rc.Participant.ID)
+	if err != nil {
+		return err
+	}
+	storedSession := &conference.SessionEvaluation{}
+	if data.EvaluateSession {
+		for _, se := range stored.Sessions {
+			if se.Session == data.SessionClass.Session {
+				storedSession = se
+				break
 			}
 		}
-		if data.EvaluateConference && eval.Conference != nil {
-			data.ConferenceEvaluation = eval.Conference
+	}
+	storedConference := &conference.ConferenceEvaluation{}
+	if stored.Conference != nil {
+		storedConference = stored.Conference
+	}
+
+	if !rc.IsPost() {
+		data.SessionEvaluation = storedSession
+		if data.EvaluateConference {
+			data.ConferenceEvaluation = storedConference
 		}
+		data.BaseHash = storedSession.Hash()
+		data.BaseHashc = storedConference.Hash()
 		return rc.Respond(s.templates.Eval2, http.StatusOK, &data)
 	}
 
@@ -161,6 +227,19 @@ func (s *service) Serve_eval(rc *requestContext) error {
 		return n
 	}
 
+	// A form submitted from another participant's page, for example an
+	// evaluation queued offline before a logout on a shared device, is a
+	// conflict.
+	data.Conflict = rc.FormValue("participant") != "" && rc.FormValue("participant") != rc.Participant.ID
+
+	// The base hashes detect changes to the stored evaluation after the form
+	// was rendered, for example by staff or on another device while the
+	// form was queued offline. A submission equal to the stored evaluation
+	// is not a conflict so that replayed submissions are accepted.
+	changed := func(base string, storedHash string, submittedHash string) bool {
+		return base != "" && base != storedHash && storedHash != submittedHash
+	}
+
 	var eval conference.Evaluation
 	if data.EvaluateSession {
 		se := data.SessionEvaluation
@@ -175,6 +254,9 @@ func (s *service) Serve_eval(rc *requestContext) error {
 				*pv = getRating(name, true)
 			}
 		}
+		if changed(rc.FormValue("baseHash"), storedSession.Hash(), se.Hash()) {
+			data.Conflict = true
+		}
 	}
 
 	if data.EvaluateConference {
@@ -188,15 +270,139 @@ func (s *service) Serve_eval(rc *requestContext) error {
 		ce.LearnTopics = rc.FormValue("learnTopics")
 		ce.TeachTopics = rc.FormValue("teachTopics")
 		ce.Comments = rc.FormValue("confComments")
+		if changed(rc.FormValue("baseHashc"), storedConference.Hash(), ce.Hash()) {
+			data.Conflict = true
+		}
 	}
 
+	// Resubmitting the form after a conflict replaces the stored evaluation.
+	data.BaseHash = storedSession.Hash()
+	data.BaseHashc = storedConference.Hash()
+
+	if data.Conflict {
+		return rc.Respond(s.templates.Eval2, http.StatusConflict, &data)
+	}
 	if rc.HasInvalidInput() {
 		return rc.Respond(s.templates.Eval2, http.StatusOK, &data)
 	}
 
-	err := s.Store.SetEvaluation(rc.Ctx, rc.Participant.ID, &eval)
+	err = s.Store.SetEvaluation(rc.Ctx, rc.Participant.ID, &eval)
 	if err != nil {
 		return err
 	}
 	return rc.Redirect("/", application.FlashInfo, "Evaluation recorded.")
 }
+
+// Serve_unsubscribe handles the unsubscribe link in campaign email sent from
+// the dashboard.
+func (s *service) Serve_unsubscribe(rc *requestContext) error {
+	token := rc.FormValue("t")
+	address, ok := application.VerifyToken(rc.Conference.Configuration.CookieKey, application.UnsubscribePurpose, token)
+	if !ok {
+		return &application.HTTPError{
+			Status:  http.StatusBadRequest,
+			Message: "The unsubscribe link is not valid or has expired.",
+		}
+	}
+	var data = struct {
+		Address      string
+		Token        string
+		Unsubscribed bool
+	}{
+		Address: address,
+		Token:   token,
+	}
+	if rc.IsPost() {
+		if err := s.Store.Unsubscribe(rc.Ctx, address); err != nil {
+			return err
+		}
+		data.Unsubscribed = true
+	}
+	return rc.Respond(s.templates.Unsubscribe, http.StatusOK, &data)
+}
+
+func (s *service) Serve_changeClass(rc *requestContext) error {
+	if rc.Participant == nil || !rc.Site.Schedule {
+		http.Redirect(rc.Response, rc.Request, "/", http.StatusSeeOther)
+		return nil
+	}
+
+	var data = struct {
+		SessionClasses []*conference.SessionClass
+		Classes        []*conference.Class
+		Available      func(*conference.Class) string
+		Error          string
+	}{
+		SessionClasses: rc.Conference.ParticipantSessionClasses(rc.Participant),
+		Classes:        rc.Conference.SwapClasses(rc.Participant, rc.Site.Time),
+	}
+	registered := rc.Conference.ClassRegistrations()
+	data.Available = func(c *conference.Class) string {
+		if n, ok := c.Available(registered); ok {
+			return fmt.Sprintf("%d seats", n)
+		}
+		return ""
+	}
+
+	if rc.IsPost() {
+		n, _ := strconv.Atoi(rc.FormValue("class"))
+		// The cached conference may be out of date. Check the swap against
+		// the registrations read in the store transaction.
+		var swapErr error
+		err := s.Store.SetParticipantClasses(rc.Ctx, rc.Participant.ID, func(conf *conference.Conference) ([]int, error) {
+			p := conf.Participant(rc.Participant.ID)
+			if p == nil {
+				swapErr = errors.New("Your registration was not found.")
+				return nil, swapErr
+			}
+			var classes []int
+			classes, swapErr = conf.SwapClass(p, n, rc.Site.Time)
+			return classes, swapErr
+		})
+		if swapErr != nil {
+			data.Error = swapErr.Error()
+			return rc.Respond(s.templates.ChangeClass, http.StatusOK, &data)
+		}
+		if err != nil {
+			return err
+		}
+		return rc.Redirect("/", application.FlashInfo, "Changed to class %d.", n)
+	}
+	return rc.Respond(s.templates.ChangeClass, http.StatusOK, &data)
+}
+
+// Serve_handouts_ serves the class handouts uploaded by instructors at
+// /handouts/{class}/{name}. Participants can download the handouts for the
+// classes in their schedule and the classes they teach.
+func (s *service) Serve_handouts_(rc *requestContext) error {
+	if rc.Participant == nil {
+		http.Redirect(rc.Response, rc.Request, "/", http.StatusSeeOther)
+		return nil
+	}
+	parts := strings.SplitN(strings.TrimPrefix(rc.Request.URL.Path, "/handouts/"), "/", 2)
+	if len(parts) != 2 {
+		return application.ErrNotFound
+	}
+	n, _ := strconv.Atoi(parts[0])
+	if !hasClass(rc.Participant.Classes, n) && !hasClass(rc.Conference.ParticipantInstructorClasses(rc.Participant), n) {
+		return application.ErrNotFound
+	}
+	h, data, err := s.Store.GetHandout(rc.Ctx, n, parts[1])
+	if err != nil {
+		return err
+	}
+	if h == nil {
+		return application.ErrNotFound
+	}
+	rc.RespondAttachment(h.Name, h.ServeContentType(), data)
+	return nil
+}
+
+func hasClass(classNumbers []int, n int) bool {
+	for _, m := range classNumbers {
+		if m != 0 && m == n {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,137 @@
+package participant
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/seaptc/seaptc/application"
+	"github.com/seaptc/seaptc/conference"
+	"github.com/seaptc/seaptc/mail"
+)
+
+// Participants who do not have their login code can request a login link by
+// email. A link is sent for each participant registered with the email
+// address, because families share a registration email. The link contains
+// the participant ID signed with the cookie key.
+
+const (
+	loginLinkPurpose = "loginLink"
+	loginLinkMaxAge  = 2 * 24 * 3600
+)
+
+// loginLinkOpen returns true if participants can use login links. Links work
+// while login with a code or evaluation is open.
+func (rc *requestContext) loginLinkOpen() bool {
+	return rc.Site.Login || rc.Site.Evaluation
+}
+
+// LoginLinkAllowed returns true if participants can request login links.
+// Requests are turned off when mail is not configured.
+func (rc *requestContext) LoginLinkAllowed() bool {
+	return rc.loginLinkOpen() && rc.mailConfigured
+}
+
+func (s *service) Serve_loginLink(rc *requestContext) error {
+	if !rc.LoginLinkAllowed() {
+		http.Redirect(rc.Response, rc.Request, "/", http.StatusSeeOther)
+		return nil
+	}
+	var data struct {
+		Wait time.Duration
+		Sent bool
+	}
+	if rc.IsPost() {
+		email := strings.ToLower(strings.TrimSpace(rc.FormValue("email")))
+		if !strings.Contains(email, "@") {
+			rc.MarkInputInvalid("email")
+			return rc.Respond(s.templates.LoginLink, http.StatusOK, &data)
+		}
+
+		// Every request counts as an attempt to limit the mail sent to an
+		// address and guessing of registered addresses. The keys are
+		// separate from the login keys so that link requests do not delay
+		// login with a code.
+		keys := append(rc.ScopedAttemptKeys("link"), "email:"+email)
+		wait, err := s.Throttle.Wait(rc.Ctx, keys...)
+		if err != nil {
+			return err
+		}
+		if wait > 0 {
+			data.Wait = wait.Round(time.Second)
+			return rc.Respond(s.templates.LoginLink, http.StatusTooManyRequests, &data)
+		}
+		if err := s.Throttle.Fail(rc.Ctx, "login link", keys...); err != nil {
+			return err
+		}
+
+		mailer, err := s.Mailer(rc.Conference)
+		if err != nil {
+			return err
+		}
+		for _, p := range participantsWithEmail(rc.Conference, email) {
+			if err := s.sendLoginLink(rc, mailer, email, p); err != nil {
+				return err
+			}
+		}
+
+		// The response does not depend on whether the address is registered.
+		data.Sent = true
+	}
+	return rc.Respond(s.templates.LoginLink, http.StatusOK, &data)
+}
+
+func participantsWithEmail(conf *conference.Conference, email string) []*conference.Participant {
+	var result []*conference.Participant
+	for _, p := range conf.Participants() {
+		for _, e := range p.Emails() {
+			if e != "" && strings.ToLower(e) == email {
+				result = append(result, p)
+				break
+			}
+		}
+	}
+	return result
+}
+
+func (s *service) sendLoginLink(rc *requestContext, mailer mail.Sender, email string, p *conference.Participant) error {
+	token := application.SignToken(rc.Conference.Configuration.CookieKey, loginLinkMaxAge, loginLinkPurpose, p.ID)
+	link := fmt.Sprintf("%s://%s/loginToken?t=%s", s.Protocol, rc.Request.Host, url.QueryEscape(token))
+
+	var body strings.Builder
+	fmt.Fprintf(&body, "Hello %s,\n\n", p.NicknameOrFirstName())
+	fmt.Fprintf(&body, "Use this link to login to the PTC participant website as %s:\n\n", p.Name())
+	fmt.Fprintf(&body, "%s\n\n", link)
+	fmt.Fprintf(&body, "The link expires in %d days. If you did not request the link, ignore this message.\n", loginLinkMaxAge/(24*3600))
+
+	return mailer.Send(rc.Ctx, &mail.Message{
+		To:      []string{email},
+		Subject: fmt.Sprintf("PTC login link for %s", p.Name()),
+		Body:    body.String(),
+	})
+}
+
+func (s *service) Serve_loginToken(rc *requestContext) error {
+	if !rc.loginLinkOpen() {
+		http.Redirect(rc.Response, rc.Request, "/", http.StatusSeeOther)
+		return nil
+	}
+	var p *conference.Participant
+	if id, ok := application.VerifyToken(rc.Conference.Configuration.CookieKey, loginLinkPurpose, rc.FormValue("t")); ok {
+		p = rc.Conference.Participant(id)
+	}
+	if p == nil {
+		return &application.HTTPError{
+			Status:  http.StatusBadRequest,
+			Message: "The login link is not valid or has expired. Request a new link.",
+		}
+	}
+	rc.setParticipantID(s.Protocol, p.ID)
+	rc.Participant = p
+
+	// Respond with a page instead of redirecting. Browsers do not send the
+	// strict id cookie on a redirect from a link in another site.
+	return rc.Respond(s.templates.LoginLink, http.StatusOK, nil)
+}
@@ -0,0 +1,89 @@
+package participant
+
+import (
+	"html/template"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/seaptc/seaptc/application"
+	"github.com/seaptc/seaptc/conference"
+)
+
+func TestServeLoginToken(t *testing.T) {
+	const cookieKey = "secret"
+	conf := conference.New().UpdateParticipants([]*conference.Participant{{ID: "p1", FirstName: "Pat", LastName: "Lee"}})
+	conf.Configuration.CookieKey = cookieKey
+
+	s := &service{Application: &application.Application{Protocol: "https"}}
+	s.templates.LoginLink = template.Must(template.New("").Parse("ok"))
+
+	for _, tt := range []struct {
+		name   string
+		token  string
+		status int
+	}{
+		{"valid", application.SignToken(cookieKey, loginLinkMaxAge, loginLinkPurpose, "p1"), http.StatusOK},
+		{"expired", application.SignToken(cookieKey, -60, loginLinkPurpose, "p1"), http.StatusBadRequest},
+		{"purpose", application.SignToken(cookieKey, loginLinkMaxAge, application.UnsubscribePurpose, "p1"), http.StatusBadRequest},
+		{"key", application.SignToken("other", loginLinkMaxAge, loginLinkPurpose, "p1"), http.StatusBadRequest},
+		{"participant", application.SignToken(cookieKey, loginLinkMaxAge, loginLinkPurpose, "p2"), http.StatusBadRequest},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest("GET", "/loginToken?t="+url.QueryEscape(tt.token), nil)
+			r.ParseForm()
+			w := httptest.NewRecorder()
+			rc := &requestContext{Site: &conference.SiteState{Evaluation: true}}
+			rc.Request = r
+			rc.Response = w
+			rc.Conference = conf
+
+			status := http.StatusOK
+			if err := s.Serve_loginToken(rc); err != nil {
+				status = rc.ConvertError(err).Status
+			}
+			if status != tt.status {
+				t.Fatalf("status = %d, want %d", status, tt.status)
+			}
+
+			var id string
+			for _, c := range w.Result().Cookies() {
+				if c.Name == "id" {
+					id = c.Value
+				}
+			}
+			if (id != "") != (tt.status == http.StatusOK) {
+				t.Errorf("id cookie = %q, want cookie set only for status OK", id)
+			}
+		})
+	}
+}
+
+func TestServeLoginLinkWithoutMail(t *testing.T) {
+	conf := conference.New()
+	s := &service{Application: &application.Application{Protocol: "https"}}
+
+	r := httptest.NewRequest("GET", "/loginLink", nil)
+	w := httptest.NewRecorder()
+	rc := &requestContext{Site: &conference.SiteState{Evaluation: true}}
+	rc.Request = r
+	rc.Response = w
+	rc.Conference = conf
+	rc.mailConfigured = s.MailConfigured(conf)
+
+	if rc.LoginLinkAllowed() {
+		t.Fatal("LoginLinkAllowed() = true without mail sender")
+	}
+	if err := s.Serve_loginLink(rc); err != nil {
+		t.Fatal(err)
+	}
+	if w.Code != http.StatusSeeOther {
+		t.Errorf("status = %d, want %d", w.Code, http.StatusSeeOther)
+	}
+
+	conf.Configuration.MailSender = "log"
+	if !s.MailConfigured(conf) {
+		t.Error("MailConfigured() = false with mail sender in configuration")
+	}
+}
@@ -0,0 +1,16 @@
+package participant
+
+import (
+	"net/http"
+	"path/filepath"
+)
+
+// Serve_serviceWorker serves the service worker script for the offline
+// participant site. The script is served from the root path so that the
+// worker's scope includes the entire site.
+func (s *service) Serve_serviceWorker(rc *requestContext) error {
+	rc.Response.Header().Set("Content-Type", "application/javascript")
+	rc.Response.Header().Set("Cache-Control", "no-cache")
+	http.ServeFile(rc.Response, rc.Request, filepath.Join(s.AssetsDir, "static", "sw.js"))
+	return nil
+}
@@ -3,10 +3,10 @@ package participant
 import (
 	"html/template"
 	"net/http"
-	"time"
 
 	"github.com/seaptc/seaptc/application"
 	"github.com/seaptc/seaptc/conference"
+	"github.com/seaptc/seaptc/store"
 )
 
 type service struct {
@@ -14,21 +14,17 @@ type service struct {
 	templates templates
 }
 
-const (
-	// Before the conference, no login allowed.
-	stateBefore = iota
-	// Data entry and login allowed.
-	stateLoginEdit
-	// Data entry allowed, no login.
-	stateEdit
-	// After the conference, no data entry or login allowed.
-	stateAfter
-)
-
 type requestContext struct {
 	application.RequestContext
 	Participant *conference.Participant
-	state       int
+
+	// Site is the state of the site features for the request time.
+	Site *conference.SiteState
+
+	// Preview is true when staff preview the site at another time.
+	Preview bool
+
+	mailConfigured bool
 }
 
 func New() application.Service { return &service{} }
@@ -45,24 +41,20 @@ func (s *service) Execute(fn interface{}, w http.ResponseWriter, r *http.Request
 		s.handleError(rc, err)
 		return
 	}
-
-	since := time.Since(rc.Conference.Date)
-	if s.TimeOverride != 0 {
-		since = s.TimeOverride
+	if err := rc.CheckCSRF(); err != nil {
+		s.handleError(rc, err)
+		return
 	}
 
-	switch {
-	case since < 0:
-		rc.state = stateBefore
-	case since < 24*time.Hour:
-		rc.state = stateLoginEdit
-	case since < 48*time.Hour:
-		rc.state = stateEdit
-	default:
-		rc.state = stateAfter
+	now := s.Now(rc.Conference)
+	if t, ok := rc.SitePreview(); ok {
+		now = t
+		rc.Preview = true
 	}
+	rc.Site = rc.Conference.ParticipantSiteState(now)
+	rc.mailConfigured = s.MailConfigured(rc.Conference)
 
-	if rc.state == stateLoginEdit || rc.state == stateEdit {
+	if rc.Site.Open() {
 		if c, _ := rc.Request.Cookie("id"); c != nil {
 			if s, ok := application.VerifySignature(rc.Conference.Configuration.CookieKey, c.Value); ok {
 				parts, err := application.DecodeStringsFromCookie(s)
@@ -73,6 +65,9 @@ func (s *service) Execute(fn interface{}, w http.ResponseWriter, r *http.Request
 			}
 		}
 	}
+	if rc.Participant != nil {
+		rc.Ctx = store.WithActor(rc.Ctx, "participant:"+rc.Participant.ID)
+	}
 
 	err = fn.(func(*service, *requestContext) error)(s, rc)
 	if err != nil {
@@ -11,10 +11,14 @@ import (
 	"io/ioutil"
 	"log"
 	"os"
+	"os/user"
 	"sort"
+	"strconv"
 	"strings"
 
 	"github.com/seaptc/seaptc/conference"
+	"github.com/seaptc/seaptc/notify"
+	"github.com/seaptc/seaptc/sheet"
 	"github.com/seaptc/seaptc/store"
 )
 
@@ -23,11 +27,19 @@ func main() {
 	log.SetFlags(0)
 	projectID := flag.String("p", store.DefaultProjectID(), "Project for Datastore")
 	useEmulator := flag.Bool("e", true, "Use Datastore emulator")
+	notifyTopic := flag.String("notify", "", "Announce changes on this Pub/Sub topic")
 	flag.Parse()
 	s, err := store.New(ctx, *projectID, *useEmulator)
 	if err != nil {
 		log.Fatal(err)
 	}
+	if *notifyTopic != "" {
+		n, err := notify.NewPubSub(ctx, *projectID, *notifyTopic)
+		if err != nil {
+			log.Fatal(err)
+		}
+		s.SetNotifier(n)
+	}
 
 	if flag.Arg(0) == "help" {
 		help()
@@ -44,6 +56,12 @@ func main() {
 		log.Fatalf("Unknown command %q, supported commands are %s", flag.Arg(0), strings.Join(names, ", "))
 	}
 
+	actor := "ptctool"
+	if u, err := user.Current(); err == nil {
+		actor += ":" + u.Username
+	}
+	ctx = store.WithActor(ctx, actor)
+
 	if err := c.fn(ctx, s); err != nil {
 		log.Fatal(err)
 	}
@@ -99,6 +117,103 @@ var commands = map[string]*command{
 		help: "Print dashboard and evaluation codes for planning speadsheet",
 		fn:   evalCodes,
 	},
+	"eval-codes-write": {
+		help: "Write missing dashboard and evaluation codes to planning spreadsheet. Use -n for dry run.",
+		fn:   evalCodesWrite,
+	},
+	"classes-import": {
+		help: "Read classes from CSV, XLSX or JSON file and save to datastore.",
+		fn: func(ctx context.Context, s *store.Store) error {
+			report, err := sheet.ReadFile(flag.Arg(1))
+			if err != nil {
+				return err
+			}
+			for _, p := range report.Problems {
+				log.Print(p)
+			}
+			if err := report.Err(); err != nil {
+				return errors.New("classes not imported, fix errors and try again")
+			}
+			if err := s.PutClasses(ctx, report.Classes); err != nil {
+				return err
+			}
+			log.Printf("%d classes imported", len(report.Classes))
+			return nil
+		}},
+	"audit": {
+		help: "Print audit log. Use -actor, -op, -target and -n to filter.",
+		fn:   audit,
+	},
+	"backup": {
+		help: "Write all blobs and evaluations to a JSON backup archive. Use -o to set the output file.",
+		fn:   backup,
+	},
+	"restore": {
+		help: "Load a backup archive into an empty store. Use -force to overwrite existing data.",
+		fn:   restore,
+	},
+	"migrate": {
+		help: "Rewrite Gob encoded blobs and evaluations in the current format. Use -n for dry run.",
+		fn: func(ctx context.Context, s *store.Store) error {
+			flags := flag.NewFlagSet("migrate", flag.ExitOnError)
+			dryRun := flags.Bool("n", false, "Print what would be migrated without writing")
+			flags.Parse(flag.Args()[1:])
+
+			blobNames, evalCount, err := s.MigrateEncoding(ctx, *dryRun)
+			if err != nil {
+				return err
+			}
+			for _, name := range blobNames {
+				log.Printf("migrated blob %s", name)
+			}
+			log.Printf("migrated %d evaluations", evalCount)
+			return nil
+		}},
+	"history": {
+		help: "List saved versions of named blob.",
+		fn: func(ctx context.Context, s *store.Store) error {
+			versions, err := s.BlobHistory(ctx, flag.Arg(1))
+			if err != nil {
+				return err
+			}
+			for _, v := range versions {
+				t := "-"
+				if !v.Time.IsZero() {
+					t = v.Time.Local().Format("2006-01-02 15:04:05")
+				}
+				fmt.Printf("%d %s %s %d bytes\n", v.Version, t, v.Actor, len(v.Data))
+			}
+			return nil
+		}},
+	"history-diff": {
+		help: "Print differences between two versions of named blob: history-diff name from to",
+		fn: func(ctx context.Context, s *store.Store) error {
+			from, err := strconv.ParseInt(flag.Arg(2), 10, 64)
+			if err != nil {
+				return err
+			}
+			to, err := strconv.ParseInt(flag.Arg(3), 10, 64)
+			if err != nil {
+				return err
+			}
+			lines, err := s.DiffBlobVersions(ctx, flag.Arg(1), from, to)
+			if err != nil {
+				return err
+			}
+			for _, l := range lines {
+				fmt.Println(l)
+			}
+			return nil
+		}},
+	"history-restore": {
+		help: "Save an old version of named blob as a new version: history-restore name version",
+		fn: func(ctx context.Context, s *store.Store) error {
+			version, err := strconv.ParseInt(flag.Arg(2), 10, 64)
+			if err != nil {
+				return err
+			}
+			return s.RestoreBlobVersion(ctx, flag.Arg(1), version)
+		}},
 	"classes-print": {
 		help: "Print class listing as text.",
 		fn: func(ctx context.Context, s *store.Store) error {
@@ -113,13 +228,128 @@ var commands = map[string]*command{
 		}},
 }
 
+func audit(ctx context.Context, s *store.Store) error {
+	flags := flag.NewFlagSet("audit", flag.ExitOnError)
+	var q store.AuditQuery
+	flags.StringVar(&q.Actor, "actor", "", "Show records with actor containing this string")
+	flags.StringVar(&q.Operation, "op", "", "Show records for this operation: "+strings.Join(store.AuditOperations, ", "))
+	flags.StringVar(&q.Target, "target", "", "Show records with target containing this string")
+	flags.IntVar(&q.Limit, "n", 100, "Maximum number of records")
+	flags.Parse(flag.Args()[1:])
+
+	records, err := s.GetAuditRecords(ctx, &q)
+	if err != nil {
+		return err
+	}
+	for _, r := range records {
+		fmt.Printf("%s %s %s %s: %s\n", r.Time.Local().Format("2006-01-02 15:04:05"), r.Actor, r.Operation, r.Target, r.Summary)
+	}
+	return nil
+}
+
+func backup(ctx context.Context, s *store.Store) error {
+	flags := flag.NewFlagSet("backup", flag.ExitOnError)
+	output := flags.String("o", "", "Output file, default is stdout")
+	flags.Parse(flag.Args()[1:])
+
+	if *output == "" {
+		return s.Backup(ctx, os.Stdout)
+	}
+	f, err := os.Create(*output)
+	if err != nil {
+		return err
+	}
+	if err := s.Backup(ctx, f); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+func restore(ctx context.Context, s *store.Store) error {
+	flags := flag.NewFlagSet("restore", flag.ExitOnError)
+	force := flags.Bool("force", false, "Overwrite data in a store that is not empty or complete a failed restore")
+	flags.Parse(flag.Args()[1:])
+
+	f, err := os.Open(flags.Arg(0))
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	err = s.Restore(ctx, f, *force)
+	if err == store.ErrStoreNotEmpty {
+		return errors.New("store is not empty, use -force to overwrite")
+	}
+	return err
+}
+
 func evalCodes(ctx context.Context, s *store.Store) error {
 	conf, _, err := s.GetConference(ctx, false)
 	if err != nil {
 		return err
 	}
 	classes := conf.Classes()
+	if err := assignCodes(classes); err != nil {
+		return err
+	}
 
+	// Quote tokens and codes in output to prevent spreadsheet from
+	// interpreting the values as numbers.
+	fmt.Printf("\"class\",\"accessToken\",\"evaluationCodes\"\n")
+	for _, class := range classes {
+		fmt.Printf("\"%d\",\"=\"\"%s\"\"\",\"=\"\"%s\"\"\"\n", class.Number, class.AccessToken, strings.Join(class.EvaluationCodes, ", "))
+	}
+	return nil
+}
+
+func evalCodesWrite(ctx context.Context, s *store.Store) error {
+	flags := flag.NewFlagSet("eval-codes-write", flag.ExitOnError)
+	dryRun := flags.Bool("n", false, "Print changes without writing to the spreadsheet")
+	sheetURL := flags.String("url", "", "Classes sheet URL, default is from configuration")
+	flags.Parse(flag.Args()[1:])
+
+	if *sheetURL == "" {
+		conf, _, err := s.GetConference(ctx, false)
+		if err != nil {
+			return err
+		}
+		*sheetURL = conf.Configuration.ClassesSheetURL
+	}
+
+	// Read the classes from the sheet to avoid clobbering edits made
+	// since the last refresh.
+	classes, err := sheet.GetClasses(ctx, *sheetURL)
+	if err != nil {
+		return err
+	}
+	if err := assignCodes(classes); err != nil {
+		return err
+	}
+
+	updates, err := sheet.WriteCodes(ctx, *sheetURL, classes, *dryRun)
+	if err != nil {
+		return err
+	}
+	conflicts := 0
+	for _, u := range updates {
+		if u.Conflict {
+			conflicts++
+			fmt.Printf("! %s\n", u)
+		} else {
+			fmt.Printf("  %s\n", u)
+		}
+	}
+	verb := "Wrote"
+	if *dryRun {
+		verb = "Would write"
+	}
+	fmt.Printf("%s %d cells, skipped %d cells with existing values.\n", verb, len(updates)-conflicts, conflicts)
+	return nil
+}
+
+// assignCodes assigns access tokens and evaluation codes to classes that do
+// not have them.
+func assignCodes(classes []*conference.Class) error {
 	// Collect codes in use.
 	evaluationCodes := make(map[string]int)
 	accessTokens := make(map[string]int)
@@ -192,13 +422,6 @@ func evalCodes(ctx context.Context, s *store.Store) error {
 		class.EvaluationCodes = codes
 	}
 	conference.SortClasses(classes, "")
-
-	// Quote tokens and codes in output to prevent spreadsheet from
-	// interpreting the values as numbers.
-	fmt.Printf("\"class\",\"accessToken\",\"evaluationCodes\"\n")
-	for _, class := range classes {
-		fmt.Printf("\"%d\",\"=\"\"%s\"\"\",\"=\"\"%s\"\"\"\n", class.Number, class.AccessToken, strings.Join(class.EvaluationCodes, ", "))
-	}
 	return nil
 }
 
@@ -34,6 +34,7 @@ var setters = []struct {
 	{"instructorEmails", func(c *class, s string) error { return setList(&c.InstructorEmails, strings.ToLower(s)) }},
 	{"evaluationCodes", func(c *class, s string) error { return setList(&c.EvaluationCodes, s) }},
 	{"accessToken", func(c *class, s string) error { return setString(&c.AccessToken, s) }},
+	{"series", func(c *class, s string) error { return setString(&c.Series, s) }},
 	{"cub", func(c *class, s string) error { return setProgram(c, 1<<conference.CubScoutProgram, s) }},
 	{"bsa", func(c *class, s string) error { return setProgram(c, 1<<conference.ScoutsBSAProgram, s) }},
 	{"ven", func(c *class, s string) error { return setProgram(c, 1<<conference.VenturingProgram, s) }},
@@ -45,6 +46,12 @@ var setters = []struct {
 	{"locationCapacity", setCapacity},
 }
 
+// optionalColumns are columns added after the sheet format was established.
+// Sheets without these columns are accepted.
+var optionalColumns = map[string]bool{
+	"series": true,
+}
+
 var (
 	listDelimPattern       = regexp.MustCompile(`[\t\r\n;, ]+`)
 	wsPattern              = regexp.MustCompile(`[\r\n\t ]+`)
@@ -121,19 +128,24 @@ func setCapacity(c *class, s string) error {
 	return nil
 }
 
-func parseClasses(r io.Reader) ([]*conference.Class, error) {
+func parseClasses(r io.Reader) (*Report, error) {
 	var sheet struct {
 		Rows [][]string `json:"values"`
 	}
 	if err := json.NewDecoder(r).Decode(&sheet); err != nil {
 		return nil, err
 	}
+	return parseRows(sheet.Rows)
+}
 
-	if len(sheet.Rows) < 1 {
+// parseRows parses classes from rows of cells. The first row is the header.
+// Problems with the cells are recorded in the returned report.
+func parseRows(rows [][]string) (*Report, error) {
+	if len(rows) < 1 {
 		return nil, errors.New("could not find header row")
 	}
 
-	header := sheet.Rows[0]
+	header := rows[0]
 	columnIndex := map[string]int{}
 	for j, name := range header {
 		name = strings.TrimSpace(name)
@@ -142,26 +154,38 @@ func parseClasses(r io.Reader) ([]*conference.Class, error) {
 		}
 	}
 	for _, s := range setters {
-		if _, ok := columnIndex[s.name]; !ok {
+		if _, ok := columnIndex[s.name]; !ok && !optionalColumns[s.name] {
 			return nil, fmt.Errorf("could not find column %q in sheet", s.name)
 		}
 	}
 
-	var result []*conference.Class
-	for i := 1; i < len(sheet.Rows); i++ {
-		row := sheet.Rows[i]
+	report := &Report{}
+	for i := 1; i < len(rows); i++ {
+		row := rows[i]
 		if len(row) < 1 || !classNumberPattern.MatchString(row[0]) {
+			for _, cell := range row {
+				if strings.TrimSpace(cell) != "" {
+					first := ""
+					if len(row) > 0 {
+						first = row[0]
+					}
+					report.warnf(i+1, "", 0, "Row skipped, first cell %q is not a class number.", first)
+					break
+				}
+			}
 			continue
 		}
 		var c class
+		ok := true
 		for _, s := range setters {
-			j := columnIndex[s.name]
-			if j >= len(row) {
+			j, found := columnIndex[s.name]
+			if !found || j >= len(row) {
 				continue
 			}
 			cell := strings.TrimSpace(wsPattern.ReplaceAllLiteralString(row[j], " "))
 			if err := s.fn(&c, cell); err != nil {
-				return nil, fmt.Errorf("sheet (%d, %s): %v", i, s.name, err)
+				report.errorf(i+1, s.name, c.Number, "Bad value %q: %v", cell, err)
+				ok = false
 			}
 		}
 		if c.Number == 700 {
@@ -173,10 +197,20 @@ func parseClasses(r io.Reader) ([]*conference.Class, error) {
 		}
 		c.Start = c.Number/100 - 1
 		c.End = c.Start + c.Length - 1
-		if c.Start < 0 || c.End < 0 || c.Start >= conference.NumSession || c.End >= conference.NumSession {
-			return nil, fmt.Errorf("class %d has bad number or length (%d)", c.Number, c.Length)
+		if err := checkSessions(&c.Class); err != nil {
+			report.errorf(i+1, "length", c.Number, "%v", err)
+			ok = false
 		}
-		result = append(result, &c.Class)
+		if ok {
+			report.add(&c.Class, i+1)
+		}
+	}
+	report.check()
+	return report, nil
+}
+func checkSessions(c *conference.Class) error {
+	if c.Start < 0 || c.End < c.Start || c.Start >= conference.NumSession || c.End >= conference.NumSession {
+		return fmt.Errorf("class %d has bad number or length (%d)", c.Number, c.Length())
 	}
-	return result, nil
+	return nil
 }
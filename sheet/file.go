@@ -0,0 +1,256 @@
+package sheet
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/seaptc/seaptc/conference"
+)
+
+// ReadFile reads classes from a local CSV, XLSX or JSON file.
+func ReadFile(name string) (*Report, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return ParseFile(filepath.Base(name), f)
+}
+
+// ParseFile parses classes from a file. The format is determined by the
+// extension of the file name.
+//
+// CSV and XLSX files must have a header row with the same column names as the
+// planning spreadsheet. The first worksheet in a XLSX file is used.
+//
+// JSON files are a Sheets values API response ({"values": [[...], ...]}) or
+// an array of classes as printed by sheet/dump.go.
+func ParseFile(name string, r io.Reader) (*Report, error) {
+	ext := strings.ToLower(path.Ext(name))
+	switch ext {
+	case ".csv", ".xlsx", ".json":
+	default:
+		return nil, fmt.Errorf("%s: unknown file type, expected .csv, .xlsx or .json", name)
+	}
+	p, err := readFile(r)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", name, err)
+	}
+	switch ext {
+	case ".csv":
+		rows, err := csvRows(bytes.NewReader(p))
+		if err != nil {
+			return nil, err
+		}
+		return parseRows(rows)
+	case ".xlsx":
+		rows, err := xlsxRows(bytes.NewReader(p), int64(len(p)))
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", name, err)
+		}
+		return parseRows(rows)
+	default:
+		return parseJSON(p)
+	}
+}
+
+const (
+	// maxFileSize is the maximum size of a file read by ParseFile. The
+	// planning spreadsheet is well under 1 MB in any format.
+	maxFileSize = 10 << 20
+
+	// maxXLSXSize is the maximum total uncompressed size of the entries in
+	// a XLSX file.
+	maxXLSXSize = 50 << 20
+)
+
+var errFileTooLarge = errors.New("file is too large")
+
+// readFile reads r to the end or returns an error if r has more than
+// maxFileSize bytes.
+func readFile(r io.Reader) ([]byte, error) {
+	p, err := ioutil.ReadAll(io.LimitReader(r, maxFileSize+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(p) > maxFileSize {
+		return nil, errFileTooLarge
+	}
+	return p, nil
+}
+
+func csvRows(r io.Reader) ([][]string, error) {
+	cr := csv.NewReader(r)
+	cr.FieldsPerRecord = -1
+	rows, err := cr.ReadAll()
+	if err != nil {
+		return nil, err
+	}
+	if len(rows) > 0 && len(rows[0]) > 0 {
+		// Remove byte order mark written by Excel.
+		rows[0][0] = strings.TrimPrefix(rows[0][0], "\ufeff")
+	}
+	return rows, nil
+}
+
+func parseJSON(p []byte) (*Report, error) {
+	if !bytes.HasPrefix(bytes.TrimSpace(p), []byte("[")) {
+		return parseClasses(bytes.NewReader(p))
+	}
+	var classes []*conference.Class
+	if err := json.Unmarshal(p, &classes); err != nil {
+		return nil, err
+	}
+	report := &Report{}
+	for _, c := range classes {
+		if err := checkSessions(c); err != nil {
+			report.errorf(0, "", c.Number, "%v", err)
+			continue
+		}
+		report.add(c, 0)
+	}
+	report.check()
+	return report, nil
+}
+
+// xlsxRows returns the cell values from the first worksheet in an Office
+// Open XML workbook.
+func xlsxRows(r io.ReaderAt, size int64) ([][]string, error) {
+	zr, err := zip.NewReader(r, size)
+	if err != nil {
+		return nil, err
+	}
+	// The zip reader returns an error when an entry decompresses to more
+	// than its declared size, so checking the declared sizes bounds the
+	// memory used to decode the workbook.
+	var total uint64
+	files := make(map[string]*zip.File)
+	for _, f := range zr.File {
+		if f.UncompressedSize64 > maxXLSXSize-total {
+			return nil, errFileTooLarge
+		}
+		total += f.UncompressedSize64
+		files[f.Name] = f
+	}
+
+	decode := func(name string, v interface{}) error {
+		f := files[name]
+		if f == nil {
+			return fmt.Errorf("missing %s", name)
+		}
+		rc, err := f.Open()
+		if err != nil {
+			return err
+		}
+		defer rc.Close()
+		return xml.NewDecoder(rc).Decode(v)
+	}
+
+	var workbook struct {
+		Sheets []struct {
+			ID string `xml:"http://schemas.openxmlformats.org/officeDocument/2006/relationships id,attr"`
+		} `xml:"sheets>sheet"`
+	}
+	if err := decode("xl/workbook.xml", &workbook); err != nil {
+		return nil, err
+	}
+	if len(workbook.Sheets) == 0 {
+		return nil, errors.New("workbook does not have a worksheet")
+	}
+
+	var rels struct {
+		Relationships []struct {
+			ID     string `xml:"Id,attr"`
+			Target string `xml:"Target,attr"`
+		} `xml:"Relationship"`
+	}
+	if err := decode("xl/_rels/workbook.xml.rels", &rels); err != nil {
+		return nil, err
+	}
+	sheetName := ""
+	for _, rel := range rels.Relationships {
+		if rel.ID == workbook.Sheets[0].ID {
+			if strings.HasPrefix(rel.Target, "/") {
+				sheetName = rel.Target[1:]
+			} else {
+				sheetName = path.Join("xl", rel.Target)
+			}
+		}
+	}
+
+	type richText struct {
+		T    string `xml:"t"`
+		Runs []struct {
+			T string `xml:"t"`
+		} `xml:"r"`
+	}
+	text := func(rt *richText) string {
+		s := rt.T
+		for _, r := range rt.Runs {
+			s += r.T
+		}
+		return s
+	}
+
+	var sharedStrings struct {
+		Items []richText `xml:"si"`
+	}
+	if files["xl/sharedStrings.xml"] != nil {
+		if err := decode("xl/sharedStrings.xml", &sharedStrings); err != nil {
+			return nil, err
+		}
+	}
+
+	var worksheet struct {
+		Rows []struct {
+			Cells []struct {
+				Ref    string   `xml:"r,attr"`
+				Type   string   `xml:"t,attr"`
+				Value  string   `xml:"v"`
+				Inline richText `xml:"is"`
+			} `xml:"c"`
+		} `xml:"sheetData>row"`
+	}
+	if err := decode(sheetName, &worksheet); err != nil {
+		return nil, err
+	}
+
+	var rows [][]string
+	for _, row := range worksheet.Rows {
+		var values []string
+		for _, c := range row.Cells {
+			j := len(values)
+			if m := a1Pattern.FindStringSubmatch(c.Ref); m != nil {
+				j = columnNumber(m[1])
+			}
+			for len(values) <= j {
+				values = append(values, "")
+			}
+			switch c.Type {
+			case "s":
+				var i int
+				if _, err := fmt.Sscan(c.Value, &i); err != nil || i < 0 || i >= len(sharedStrings.Items) {
+					return nil, fmt.Errorf("bad shared string index in cell %s", c.Ref)
+				}
+				values[j] = text(&sharedStrings.Items[i])
+			case "inlineStr":
+				values[j] = text(&c.Inline)
+			default:
+				values[j] = c.Value
+			}
+		}
+		rows = append(rows, values)
+	}
+	return rows, nil
+}
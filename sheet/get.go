@@ -4,18 +4,34 @@ import (
 	"context"
 	"fmt"
 	"io"
+	"net/http"
 	"os"
+	"strings"
 
 	"github.com/seaptc/seaptc/conference"
 	"golang.org/x/oauth2/google"
 )
 
-func getBody(ctx context.Context, url string) (io.ReadCloser, error) {
-	client, err := google.DefaultClient(ctx, "https://www.googleapis.com/auth/spreadsheets.readonly")
+const (
+	readOnlyScope  = "https://www.googleapis.com/auth/spreadsheets.readonly"
+	readWriteScope = "https://www.googleapis.com/auth/spreadsheets"
+)
+
+// httpClient returns a client for the given sheet URL. Plain HTTP URLs, as
+// used by the fake Sheets server in tests, get an unauthenticated client.
+func httpClient(ctx context.Context, url string, scope string) (*http.Client, error) {
+	if strings.HasPrefix(url, "http://") {
+		return http.DefaultClient, nil
+	}
+	return google.DefaultClient(ctx, scope)
+}
+
+func getBody(ctx context.Context, client *http.Client, url string) (io.ReadCloser, error) {
+	req, err := http.NewRequest("GET", url, nil)
 	if err != nil {
 		return nil, err
 	}
-	resp, err := client.Get(url)
+	resp, err := client.Do(req.WithContext(ctx))
 	if err != nil {
 		return nil, err
 	}
@@ -27,11 +43,26 @@ func getBody(ctx context.Context, url string) (io.ReadCloser, error) {
 	return resp.Body, nil
 }
 
-func GetClasses(ctx context.Context, url string) ([]*conference.Class, error) {
-	r, err := getBody(ctx, url)
+// Fetch reads classes from the planning sheet using the Sheets values API.
+func Fetch(ctx context.Context, url string) (*Report, error) {
+	client, err := httpClient(ctx, url, readOnlyScope)
+	if err != nil {
+		return nil, err
+	}
+	r, err := getBody(ctx, client, url)
 	if err != nil {
 		return nil, err
 	}
 	defer r.Close()
 	return parseClasses(r)
 }
+
+// GetClasses reads classes from the planning sheet. An error is returned if
+// the validation report has errors.
+func GetClasses(ctx context.Context, url string) ([]*conference.Class, error) {
+	report, err := Fetch(ctx, url)
+	if err != nil {
+		return nil, err
+	}
+	return report.Classes, report.Err()
+}
@@ -0,0 +1,229 @@
+package sheet
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/seaptc/seaptc/conference"
+)
+
+// Problem is an error or warning found when validating classes.
+type Problem struct {
+	Row     int    // sheet row, 1 based; 0 if not known
+	Column  string // column name from the header row
+	Class   int    // class number; 0 if not known
+	Message string
+	Warning bool
+}
+
+func (p *Problem) String() string {
+	var parts []string
+	if p.Row > 0 {
+		parts = append(parts, fmt.Sprintf("row %d", p.Row))
+	}
+	if p.Column != "" {
+		parts = append(parts, p.Column)
+	}
+	if p.Class != 0 {
+		parts = append(parts, fmt.Sprintf("class %d", p.Class))
+	}
+	kind := "error"
+	if p.Warning {
+		kind = "warning"
+	}
+	return fmt.Sprintf("%s (%s): %s", kind, strings.Join(parts, ", "), p.Message)
+}
+
+// Report is the result of reading classes from a sheet or file. Classes with
+// errors are not included in Classes.
+type Report struct {
+	Classes  []*conference.Class
+	Problems []*Problem
+
+	rows []int // sheet row for Classes[i]
+}
+
+func (r *Report) add(c *conference.Class, row int) {
+	r.Classes = append(r.Classes, c)
+	r.rows = append(r.rows, row)
+}
+
+func (r *Report) errorf(row int, column string, class int, format string, args ...interface{}) {
+	r.Problems = append(r.Problems, &Problem{Row: row, Column: column, Class: class, Message: fmt.Sprintf(format, args...)})
+}
+
+func (r *Report) warnf(row int, column string, class int, format string, args ...interface{}) {
+	r.Problems = append(r.Problems, &Problem{Row: row, Column: column, Class: class, Message: fmt.Sprintf(format, args...), Warning: true})
+}
+
+// Errors returns the problems that prevent the classes from being used.
+func (r *Report) Errors() []*Problem {
+	var result []*Problem
+	for _, p := range r.Problems {
+		if !p.Warning {
+			result = append(result, p)
+		}
+	}
+	return result
+}
+
+// Warnings returns problems that should be reviewed before the classes are
+// used.
+func (r *Report) Warnings() []*Problem {
+	var result []*Problem
+	for _, p := range r.Problems {
+		if p.Warning {
+			result = append(result, p)
+		}
+	}
+	return result
+}
+
+// Err returns an error describing the first error in the report or nil if
+// the report does not have errors.
+func (r *Report) Err() error {
+	errs := r.Errors()
+	switch len(errs) {
+	case 0:
+		return nil
+	case 1:
+		return errors.New(errs[0].String())
+	default:
+		return fmt.Errorf("%s (and %d more errors)", errs[0], len(errs)-1)
+	}
+}
+
+func (r *Report) sort() {
+	sort.SliceStable(r.Problems, func(i, j int) bool {
+		a, b := r.Problems[i], r.Problems[j]
+		switch {
+		case a.Warning != b.Warning:
+			return !a.Warning
+		case a.Row != b.Row:
+			return a.Row < b.Row
+		default:
+			return a.Class < b.Class
+		}
+	})
+}
+
+// check validates the classes in the report.
+func (r *Report) check() {
+	numbers := make(map[int]int)
+	accessTokens := make(map[string]int)
+	evaluationCodes := make(map[string]int)
+
+	for i, c := range r.Classes {
+		row := r.rows[i]
+
+		if prev, ok := numbers[c.Number]; ok {
+			r.errorf(row, "number", c.Number, "Duplicate class number, also used in row %d.", prev)
+		}
+		numbers[c.Number] = row
+
+		if c.Title == "" {
+			r.warnf(row, "title", c.Number, "Missing title.")
+		}
+		if c.Location == "" {
+			r.warnf(row, "location", c.Number, "Missing location.")
+		}
+		if len(c.InstructorNames) == 0 {
+			r.warnf(row, "instructorNames", c.Number, "Missing instructors.")
+		}
+		if c.Programs == 0 {
+			r.warnf(row, "", c.Number, "No programs selected.")
+		}
+
+		switch n := len(c.EvaluationCodes); {
+		case n == 0:
+			r.warnf(row, "evaluationCodes", c.Number, "Missing evaluation codes.")
+		case n != c.Length():
+			r.warnf(row, "evaluationCodes", c.Number, "Found %d evaluation codes for class with length %d.", n, c.Length())
+		}
+		for _, code := range c.EvaluationCodes {
+			if prev, ok := evaluationCodes[code]; ok {
+				r.errorf(row, "evaluationCodes", c.Number, "Evaluation code %s also used in class %d.", code, prev)
+			}
+			evaluationCodes[code] = c.Number
+		}
+
+		if c.AccessToken != "" {
+			if prev, ok := accessTokens[c.AccessToken]; ok {
+				r.errorf(row, "accessToken", c.Number, "Access token also used in class %d.", prev)
+			}
+			accessTokens[c.AccessToken] = c.Number
+		}
+	}
+	r.sort()
+}
+
+// Validate returns a report for classes that were read from a sheet or file
+// and later returned by a client. The classes are checked again because the
+// client can modify them. Problems in the report do not have row numbers.
+func Validate(classes []*conference.Class) *Report {
+	r := &Report{}
+	for _, c := range classes {
+		if c.Number < 100 || c.Number > 999 || c.Start != c.Number/100-1 {
+			r.errorf(0, "number", c.Number, "Bad class number or sessions.")
+			continue
+		}
+		if err := checkSessions(c); err != nil {
+			r.errorf(0, "length", c.Number, "%v", err)
+			continue
+		}
+		r.add(c, 0)
+	}
+	r.check()
+	return r
+}
+
+// Compare adds warnings for differences between the classes in the report
+// and the previous classes.
+func (r *Report) Compare(previous []*conference.Class) {
+	if len(previous) == 0 {
+		return
+	}
+	prevByNumber := make(map[int]*conference.Class)
+	for _, c := range previous {
+		prevByNumber[c.Number] = c
+	}
+
+	seen := make(map[int]bool)
+	for i, c := range r.Classes {
+		row := r.rows[i]
+		seen[c.Number] = true
+		p := prevByNumber[c.Number]
+		if p == nil {
+			r.warnf(row, "number", c.Number, "New class.")
+			continue
+		}
+		if p.Title != c.Title {
+			r.warnf(row, "title", c.Number, "Title changed from %q.", p.Title)
+		}
+		if p.Start != c.Start || p.End != c.End {
+			r.warnf(row, "length", c.Number, "Sessions changed from %s to %s.", sessionRange(p), sessionRange(c))
+		}
+		if p.Location != c.Location {
+			r.warnf(row, "location", c.Number, "Location changed from %q.", p.Location)
+		}
+		if strings.Join(p.InstructorNames, ", ") != strings.Join(c.InstructorNames, ", ") {
+			r.warnf(row, "instructorNames", c.Number, "Instructors changed from %q.", strings.Join(p.InstructorNames, ", "))
+		}
+	}
+
+	for _, p := range previous {
+		if !seen[p.Number] {
+			r.warnf(0, "", p.Number, "Class %q removed.", p.Title)
+		}
+	}
+	r.sort()
+}
+
+func sessionRange(c *conference.Class) string {
+	if c.Start == c.End {
+		return fmt.Sprintf("%d", c.Start+1)
+	}
+	return fmt.Sprintf("%d-%d", c.Start+1, c.End+1)
+}
@@ -0,0 +1,249 @@
+package sheet
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/seaptc/seaptc/conference"
+)
+
+// CellUpdate is a change to a cell in the classes sheet.
+type CellUpdate struct {
+	Class  int
+	Column string // column name from the header row
+	Range  string // A1 notation
+	Old    string
+	New    string
+
+	// Conflict is set when the cell has a value different from the new
+	// value. Conflicting cells are not written.
+	Conflict bool
+}
+
+func (u *CellUpdate) String() string {
+	if u.Conflict {
+		return fmt.Sprintf("%s %d %s: keep %q, generated %q", u.Range, u.Class, u.Column, u.Old, u.New)
+	}
+	return fmt.Sprintf("%s %d %s: %q -> %q", u.Range, u.Class, u.Column, u.Old, u.New)
+}
+
+var (
+	a1Pattern = regexp.MustCompile(`^([A-Za-z]+)([0-9]*)`)
+
+	// a1RangePattern matches a range without a sheet name. Columns have at
+	// most three letters, so a name like Sheet1 is a sheet name.
+	a1RangePattern = regexp.MustCompile(`^[A-Za-z]{1,3}[0-9]*(:[A-Za-z]{0,3}[0-9]*)?$`)
+)
+
+// sheetRange is the location of the values returned by the Sheets values API.
+type sheetRange struct {
+	base      string // https://sheets.googleapis.com/v4/spreadsheets/{id}
+	sheetName string
+	row       int // first row, 1 based
+	column    int // first column, 0 based
+}
+
+func parseValuesURL(valuesURL string) (*sheetRange, error) {
+	u, err := url.Parse(valuesURL)
+	if err != nil {
+		return nil, err
+	}
+	i := strings.Index(u.Path, "/values/")
+	if i < 0 {
+		return nil, fmt.Errorf("sheet: %s is not a Sheets values URL", valuesURL)
+	}
+	r := &sheetRange{row: 1}
+	a1 := u.Path[i+len("/values/"):]
+	u.Path = u.Path[:i]
+	u.RawPath = ""
+	u.RawQuery = ""
+	r.base = u.String()
+
+	if j := strings.LastIndex(a1, "!"); j >= 0 {
+		r.sheetName = a1[:j]
+		a1 = a1[j+1:]
+	} else if !a1RangePattern.MatchString(a1) {
+		r.sheetName = a1
+		a1 = ""
+	}
+	// Quoted names escape a quote by doubling it.
+	if n := len(r.sheetName); n >= 2 && r.sheetName[0] == '\'' && r.sheetName[n-1] == '\'' {
+		r.sheetName = strings.ReplaceAll(r.sheetName[1:n-1], "''", "'")
+	}
+	if m := a1Pattern.FindStringSubmatch(a1); m != nil {
+		r.column = columnNumber(m[1])
+		if m[2] != "" {
+			r.row, _ = strconv.Atoi(m[2])
+		}
+	}
+	return r, nil
+}
+
+// columnNumber converts A, B, ..., Z, AA, ... to 0, 1, ..., 25, 26, ...
+func columnNumber(s string) int {
+	n := 0
+	for _, c := range strings.ToUpper(s) {
+		n = n*26 + int(c-'A'+1)
+	}
+	return n - 1
+}
+
+func columnName(n int) string {
+	var b []byte
+	for n++; n > 0; n = (n - 1) / 26 {
+		b = append([]byte{byte('A' + (n-1)%26)}, b...)
+	}
+	return string(b)
+}
+
+func (r *sheetRange) cell(row, column int) string {
+	a1 := columnName(r.column+column) + strconv.Itoa(r.row+row)
+	if r.sheetName == "" {
+		return a1
+	}
+	return "'" + strings.ReplaceAll(r.sheetName, "'", "''") + "'!" + a1
+}
+
+// WriteCodes writes class access tokens and evaluation codes to the classes
+// sheet at valuesURL. Cells that already have a value are never overwritten.
+// If dryRun is true, the sheet is not modified. The returned updates include
+// the conflicting cells.
+func WriteCodes(ctx context.Context, valuesURL string, classes []*conference.Class, dryRun bool) ([]*CellUpdate, error) {
+	sr, err := parseValuesURL(valuesURL)
+	if err != nil {
+		return nil, err
+	}
+
+	client, err := httpClient(ctx, valuesURL, readWriteScope)
+	if err != nil {
+		return nil, err
+	}
+
+	r, err := getBody(ctx, client, valuesURL)
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	var sheet struct {
+		Rows [][]string `json:"values"`
+	}
+	if err := json.NewDecoder(r).Decode(&sheet); err != nil {
+		return nil, err
+	}
+	if len(sheet.Rows) < 1 {
+		return nil, errors.New("could not find header row")
+	}
+
+	columnIndex := map[string]int{}
+	for j, name := range sheet.Rows[0] {
+		columnIndex[strings.TrimSpace(name)] = j
+	}
+	for _, name := range []string{"number", "accessToken", "evaluationCodes"} {
+		if _, ok := columnIndex[name]; !ok {
+			return nil, fmt.Errorf("could not find column %q in sheet", name)
+		}
+	}
+
+	classesByNumber := make(map[int]*conference.Class)
+	for _, c := range classes {
+		classesByNumber[c.Number] = c
+	}
+
+	var updates []*CellUpdate
+	for i := 1; i < len(sheet.Rows); i++ {
+		row := sheet.Rows[i]
+		j := columnIndex["number"]
+		if j >= len(row) || !classNumberPattern.MatchString(row[j]) {
+			continue
+		}
+		n, _ := strconv.Atoi(strings.TrimSpace(row[j]))
+		c := classesByNumber[n]
+		if c == nil {
+			continue
+		}
+		values := map[string]string{
+			"accessToken":     c.AccessToken,
+			"evaluationCodes": strings.Join(c.EvaluationCodes, ", "),
+		}
+		for _, name := range []string{"accessToken", "evaluationCodes"} {
+			j := columnIndex[name]
+			old := ""
+			if j < len(row) {
+				old = strings.TrimSpace(row[j])
+			}
+			value := values[name]
+			if value == "" || old == value {
+				continue
+			}
+			if name == "evaluationCodes" && old != "" {
+				var oldCodes []string
+				setList(&oldCodes, old)
+				if strings.Join(oldCodes, ", ") == value {
+					continue
+				}
+			}
+			updates = append(updates, &CellUpdate{
+				Class:    n,
+				Column:   name,
+				Range:    sr.cell(i, j),
+				Old:      old,
+				New:      value,
+				Conflict: old != "",
+			})
+		}
+	}
+
+	if dryRun {
+		return updates, nil
+	}
+
+	type valueRange struct {
+		Range  string     `json:"range"`
+		Values [][]string `json:"values"`
+	}
+	request := struct {
+		ValueInputOption string        `json:"valueInputOption"`
+		Data             []*valueRange `json:"data"`
+	}{
+		// RAW prevents the sheet from converting the codes to numbers.
+		ValueInputOption: "RAW",
+	}
+	for _, u := range updates {
+		if !u.Conflict {
+			request.Data = append(request.Data, &valueRange{Range: u.Range, Values: [][]string{{u.New}}})
+		}
+	}
+	if len(request.Data) == 0 {
+		return updates, nil
+	}
+
+	p, err := json.Marshal(&request)
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequest("POST", sr.base+"/values:batchUpdate", bytes.NewReader(p))
+	if err != nil {
+		return nil, err
+	}
+	req = req.WithContext(ctx)
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		body, _ := ioutil.ReadAll(resp.Body)
+		return nil, fmt.Errorf("update sheet returned %d: %s", resp.StatusCode, bytes.TrimSpace(body))
+	}
+	return updates, nil
+}
@@ -0,0 +1,163 @@
+package sheet
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/seaptc/seaptc/conference"
+)
+
+// fakeSheets is the subset of the Sheets v4 values API used by this package.
+// Updates are applied to Values.
+type fakeSheets struct {
+	mu      sync.Mutex
+	Values  [][]string
+	updates int
+}
+
+var fakeCellPattern = regexp.MustCompile(`([A-Z]+)([0-9]+)$`)
+
+func (f *fakeSheets) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	switch {
+	case r.Method == "GET" && strings.Contains(r.URL.Path, "/values/"):
+		json.NewEncoder(w).Encode(map[string]interface{}{"values": f.Values})
+	case r.Method == "POST" && strings.HasSuffix(r.URL.Path, "/values:batchUpdate"):
+		var request struct {
+			ValueInputOption string
+			Data             []struct {
+				Range  string
+				Values [][]string
+			}
+		}
+		if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if request.ValueInputOption != "RAW" {
+			http.Error(w, "unexpected valueInputOption "+request.ValueInputOption, http.StatusBadRequest)
+			return
+		}
+		for _, d := range request.Data {
+			m := fakeCellPattern.FindStringSubmatch(d.Range)
+			if m == nil || len(d.Values) != 1 || len(d.Values[0]) != 1 {
+				http.Error(w, "unsupported range "+d.Range, http.StatusBadRequest)
+				return
+			}
+			column := columnNumber(m[1])
+			row, _ := strconv.Atoi(m[2])
+			row--
+			for len(f.Values) <= row {
+				f.Values = append(f.Values, nil)
+			}
+			for len(f.Values[row]) <= column {
+				f.Values[row] = append(f.Values[row], "")
+			}
+			f.Values[row][column] = d.Values[0][0]
+			f.updates++
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte("{}\n"))
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func TestWriteCodes(t *testing.T) {
+	fake := &fakeSheets{Values: [][]string{
+		{"title", "number", "accessToken", "evaluationCodes"},
+		{"Knots", "101", "", ""},
+		{"Maps", "102", "keep", "1111"},
+		{"", "103"}, // short row
+		{"Lunch"},   // not a class
+		{"Other", "104", "", ""},
+	}}
+	ts := httptest.NewServer(fake)
+	defer ts.Close()
+
+	classes := []*conference.Class{
+		{Number: 101, AccessToken: "tok101", EvaluationCodes: []string{"1234"}},
+		{Number: 102, AccessToken: "tok102", EvaluationCodes: []string{"1111"}},
+		{Number: 103, AccessToken: "tok103", EvaluationCodes: []string{"3333"}},
+	}
+
+	valuesURL := ts.URL + "/v4/spreadsheets/test/values/Sheet1"
+	want := []string{
+		`'Sheet1'!C2 101 accessToken: "" -> "tok101"`,
+		`'Sheet1'!D2 101 evaluationCodes: "" -> "1234"`,
+		`'Sheet1'!C3 102 accessToken: keep "keep", generated "tok102"`,
+		`'Sheet1'!C4 103 accessToken: "" -> "tok103"`,
+		`'Sheet1'!D4 103 evaluationCodes: "" -> "3333"`,
+	}
+
+	for _, dryRun := range []bool{true, false} {
+		updates, err := WriteCodes(context.Background(), valuesURL, classes, dryRun)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var got []string
+		for _, u := range updates {
+			got = append(got, u.String())
+		}
+		if strings.Join(got, "\n") != strings.Join(want, "\n") {
+			t.Errorf("dryRun=%v, updates\n%s\nwant\n%s", dryRun, strings.Join(got, "\n"), strings.Join(want, "\n"))
+		}
+		if dryRun && fake.updates != 0 {
+			t.Errorf("dry run updated %d cells", fake.updates)
+		}
+	}
+
+	if fake.updates != 4 {
+		t.Errorf("updated %d cells, want 4", fake.updates)
+	}
+	if got := fake.Values[2][2]; got != "keep" {
+		t.Errorf("conflicting cell = %q, want %q", got, "keep")
+	}
+	if got := strings.Join(fake.Values[3], ","); got != ",103,tok103,3333" {
+		t.Errorf("short row = %q, want %q", got, ",103,tok103,3333")
+	}
+
+	// Codes are not written again.
+	updates, err := WriteCodes(context.Background(), valuesURL, classes, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(updates) != 1 || !updates[0].Conflict {
+		t.Errorf("second write returned %v, want the conflict only", updates)
+	}
+}
+
+func TestParseValuesURL(t *testing.T) {
+	const base = "https://sheets.googleapis.com/v4/spreadsheets/id"
+	for _, tt := range []struct {
+		valuesURL string
+		cell      string // cell(1, 2)
+	}{
+		{base + "/values/Sheet1", "'Sheet1'!C2"},
+		{base + "/values/Classes", "'Classes'!C2"},
+		{base + "/values/B3:Z", "D4"},
+		{base + "/values/Class%20Planning!B3:Z?alt=json", "'Class Planning'!D4"},
+		{base + "/values/'Bob''s Sheet'!A1", "'Bob''s Sheet'!C2"},
+		{base + "/values/'Class Planning'", "'Class Planning'!C2"},
+	} {
+		r, err := parseValuesURL(tt.valuesURL)
+		if err != nil {
+			t.Errorf("parseValuesURL(%q) returned error %v", tt.valuesURL, err)
+			continue
+		}
+		if r.base != base {
+			t.Errorf("parseValuesURL(%q).base = %q, want %q", tt.valuesURL, r.base, base)
+		}
+		if got := r.cell(1, 2); got != tt.cell {
+			t.Errorf("parseValuesURL(%q).cell(1, 2) = %q, want %q", tt.valuesURL, got, tt.cell)
+		}
+	}
+}
@@ -0,0 +1,52 @@
+package store
+
+import (
+	"context"
+	"time"
+
+	"cloud.google.com/go/datastore"
+	"github.com/seaptc/seaptc/throttle"
+)
+
+// Login attempt records implement throttle.Backend for instances that share
+// attempt tracking. The records are root entities, not in the conference
+// entity group, so that failed logins do not contend with conference writes.
+
+func attemptKey(key string) *datastore.Key {
+	return datastore.NameKey("loginAttempt", key, nil)
+}
+
+func (s *Store) GetAttempts(ctx context.Context, keys []string) ([]*throttle.Record, error) {
+	dkeys := make([]*datastore.Key, len(keys))
+	for i, k := range keys {
+		dkeys[i] = attemptKey(k)
+	}
+	records := make([]throttle.Record, len(keys))
+	err := s.client.GetMulti(ctx, dkeys, records)
+	errs, _ := err.(datastore.MultiError)
+	if err := noEntityOK(err); err != nil {
+		return nil, err
+	}
+	result := make([]*throttle.Record, len(keys))
+	for i := range records {
+		if errs == nil || errs[i] == nil {
+			result[i] = &records[i]
+		}
+	}
+	return result, nil
+}
+
+func (s *Store) PutAttempt(ctx context.Context, r *throttle.Record) error {
+	_, err := s.client.Put(ctx, attemptKey(r.Key), r)
+	return err
+}
+
+func (s *Store) DeleteAttempt(ctx context.Context, key string) error {
+	return s.client.Delete(ctx, attemptKey(key))
+}
+
+func (s *Store) ListAttempts(ctx context.Context, since time.Time) ([]*throttle.Record, error) {
+	var records []*throttle.Record
+	_, err := s.client.GetAll(ctx, datastore.NewQuery("loginAttempt").Filter("Last >", since), &records)
+	return records, err
+}
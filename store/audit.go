@@ -0,0 +1,287 @@
+package store
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"cloud.google.com/go/datastore"
+	"github.com/seaptc/seaptc/conference"
+	"google.golang.org/api/iterator"
+)
+
+type actorKey struct{}
+
+// WithActor returns a context that records actor as the user making changes
+// to the store. Use "staff:{id}", "participant:{id}" or "ptctool:{user}".
+func WithActor(ctx context.Context, actor string) context.Context {
+	return context.WithValue(ctx, actorKey{}, actor)
+}
+
+// Actor returns the actor set in the context with WithActor.
+func Actor(ctx context.Context) string {
+	if actor, ok := ctx.Value(actorKey{}).(string); ok && actor != "" {
+		return actor
+	}
+	return "unknown"
+}
+
+// AuditRecord records a mutation of the store.
+type AuditRecord struct {
+	Time      time.Time
+	Actor     string
+	Operation string
+	Target    string
+	Summary   string `datastore:",noindex"`
+}
+
+// putAudit adds an audit record to the transaction.
+func putAudit(ctx context.Context, tx *datastore.Transaction, operation, target, summary string) error {
+	_, err := tx.Put(datastore.IncompleteKey("audit", conferenceEntityGroupKey), &AuditRecord{
+		Time:      time.Now(),
+		Actor:     Actor(ctx),
+		Operation: operation,
+		Target:    target,
+		Summary:   summary,
+	})
+	return err
+}
+
+// AuditQuery selects audit records. Empty fields match all records.
+type AuditQuery struct {
+	Actor     string    // substring of actor
+	Operation string    // exact operation name
+	Target    string    // substring of target
+	Before    time.Time // records before this time
+	Limit     int       // maximum number of records, default 100
+}
+
+// maxAuditScan limits the number of records examined by GetAuditRecords.
+const maxAuditScan = 5000
+
+// GetAuditRecords returns matching audit records, most recent first.
+func (s *Store) GetAuditRecords(ctx context.Context, q *AuditQuery) ([]*AuditRecord, error) {
+	limit := q.Limit
+	if limit <= 0 {
+		limit = 100
+	}
+	query := datastore.NewQuery("audit").Ancestor(conferenceEntityGroupKey).Order("-Time")
+	if !q.Before.IsZero() {
+		query = query.Filter("Time <", q.Before)
+	}
+
+	actor := strings.ToLower(q.Actor)
+	target := strings.ToLower(q.Target)
+
+	var result []*AuditRecord
+	it := s.client.Run(ctx, query.Limit(maxAuditScan))
+	for len(result) < limit {
+		var r AuditRecord
+		_, err := it.Next(&r)
+		if err == iterator.Done {
+			break
+		} else if err != nil {
+			return nil, err
+		}
+		if (actor != "" && !strings.Contains(strings.ToLower(r.Actor), actor)) ||
+			(q.Operation != "" && r.Operation != q.Operation) ||
+			(target != "" && !strings.Contains(strings.ToLower(r.Target), target)) {
+			continue
+		}
+		result = append(result, &r)
+	}
+	return result, nil
+}
+
+// AuditOperations is the list of operations recorded in the audit log.
+var AuditOperations = []string{
+	"deleteBlob",
+	"deleteClassYears",
+	"deleteHandout",
+	"mergeParticipants",
+	"modifyInstructorClasses",
+	"putClassYears",
+	"putClasses",
+	"putConfiguration",
+	"putHandout",
+	"putParticipants",
+	"restoreBackup",
+	"restoreBlob",
+	"setEvaluation",
+	"setParticipantClasses",
+	"setParticipantOverride",
+	"setPrintSignatures",
+}
+
+// diffSummary returns a short summary of the added, removed and changed
+// items.
+func diffSummary(noun string, added, removed, changed []string) string {
+	var parts []string
+	for _, d := range []struct {
+		verb  string
+		items []string
+	}{{"added", added}, {"removed", removed}, {"changed", changed}} {
+		if len(d.items) == 0 {
+			continue
+		}
+		sort.Strings(d.items)
+		items := d.items
+		const maxItems = 10
+		suffix := ""
+		if len(items) > maxItems {
+			suffix = fmt.Sprintf(" and %d more", len(items)-maxItems)
+			items = items[:maxItems]
+		}
+		parts = append(parts, fmt.Sprintf("%s %s%s", d.verb, strings.Join(items, ", "), suffix))
+	}
+	if len(parts) == 0 {
+		return "no " + noun + " changed"
+	}
+	return noun + ": " + strings.Join(parts, "; ")
+}
+
+// diffMaps compares maps with the same key and value types.
+func diffMaps(old, new map[string]interface{}) (added, removed, changed []string) {
+	for k, v := range new {
+		if ov, ok := old[k]; !ok {
+			added = append(added, k)
+		} else if !reflect.DeepEqual(ov, v) {
+			changed = append(changed, k)
+		}
+	}
+	for k := range old {
+		if _, ok := new[k]; !ok {
+			removed = append(removed, k)
+		}
+	}
+	return added, removed, changed
+}
+
+func summarizeConfiguration(old, new []byte) string {
+	decode := func(data []byte) map[string]interface{} {
+		m := make(map[string]interface{})
+		if len(data) > 0 {
+			json.Unmarshal(data, &m)
+		}
+		return m
+	}
+	added, removed, changed := diffMaps(decode(old), decode(new))
+	return diffSummary("fields", nil, nil, append(append(changed, added...), removed...))
+}
+
+func summarizeClasses(old, new []byte) string {
+	decode := func(data []byte) map[string]interface{} {
+		m := make(map[string]interface{})
+		var classes []*conference.Class
+		decodeBlob(classesKey.Name, data, &classes)
+		for _, c := range classes {
+			m[strconv.Itoa(c.Number)] = c
+		}
+		return m
+	}
+	added, removed, changed := diffMaps(decode(old), decode(new))
+	return diffSummary("classes", added, removed, changed)
+}
+
+func summarizeParticipants(old, new []*conference.Participant) string {
+	m := func(participants []*conference.Participant) map[string]interface{} {
+		m := make(map[string]interface{})
+		for _, p := range participants {
+			// Login codes are assigned by the store.
+			q := *p
+			q.LoginCode = ""
+			m[p.ID] = &q
+		}
+		return m
+	}
+	added, removed, changed := diffMaps(m(old), m(new))
+	return fmt.Sprintf("%d participants, %d added, %d removed, %d changed", len(new), len(added), len(removed), len(changed))
+}
+
+func summarizeBlob(name string, old, new []byte) string {
+	switch name {
+	case configurationKey.Name:
+		return summarizeConfiguration(old, new)
+	case classesKey.Name:
+		return summarizeClasses(old, new)
+	default:
+		return fmt.Sprintf("%d bytes, was %d bytes", len(new), len(old))
+	}
+}
+
+func summarizeInstructorClasses(old, new []int) string {
+	var changes []string
+	for i := 0; i < conference.NumSession; i++ {
+		var o, n int
+		if i < len(old) {
+			o = old[i]
+		}
+		if i < len(new) {
+			n = new[i]
+		}
+		if o != n {
+			changes = append(changes, fmt.Sprintf("session %d: %d -> %d", i+1, o, n))
+		}
+	}
+	if len(changes) == 0 {
+		return "no change"
+	}
+	return strings.Join(changes, ", ")
+}
+
+func summarizeParticipantOverride(o *conference.ParticipantOverride) string {
+	if o.IsEmpty() {
+		return "cleared override"
+	}
+	var parts []string
+	for _, f := range []struct {
+		name  string
+		value *string
+	}{
+		{"nickname", o.Nickname},
+		{"lunch option", o.LunchOption},
+		{"unit type", o.UnitType},
+		{"unit number", o.UnitNumber},
+	} {
+		if f.value != nil {
+			parts = append(parts, fmt.Sprintf("%s %q", f.name, *f.value))
+		}
+	}
+	if o.Classes != nil {
+		parts = append(parts, summarizeClassNumbers(o.Classes))
+	}
+	return "set " + strings.Join(parts, ", ")
+}
+
+func summarizeClassNumbers(classes []int) string {
+	if classes == nil {
+		return "imported classes"
+	}
+	parts := make([]string, len(classes))
+	for i, n := range classes {
+		parts[i] = strconv.Itoa(n)
+	}
+	return "classes " + strings.Join(parts, " ")
+}
+
+func summarizeEvaluation(eval *conference.Evaluation) string {
+	var parts []string
+	if eval.Conference != nil {
+		parts = append(parts, "conference")
+	}
+	if eval.Note != nil {
+		parts = append(parts, "note")
+	}
+	for _, se := range eval.Sessions {
+		parts = append(parts, fmt.Sprintf("session %d class %d", se.Session+1, se.ClassNumber))
+	}
+	if len(parts) == 0 {
+		return "no change"
+	}
+	return "set " + strings.Join(parts, ", ")
+}
@@ -0,0 +1,509 @@
+package store
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"time"
+
+	"cloud.google.com/go/datastore"
+	"github.com/seaptc/seaptc/conference"
+)
+
+// A backup archive is a JSON document:
+//
+//  {
+//    "format": "seaptc-backup",
+//    "formatVersion": 1,
+//    "created": "2020-02-01T10:00:00Z",
+//    "metaVersion": 42,
+//    "manifest": [
+//      {"kind": "blob", "name": "classes", "version": 40, "sha256": "..."},
+//      {"kind": "eval", "name": "{participantID}", "sha256": "..."},
+//      {"kind": "classYears", "name": "{year}", "sha256": "..."},
+//      ...
+//    ],
+//    "items": [
+//      {"kind": "blob", "name": "classes", "version": 40, "schema": 1, "data": [...]},
+//      ...
+//    ]
+//  }
+//
+// Items are the blobs (configuration, classes, participant chunks,
+// participantOverrides, instructorClasses, participantRedirects, loginCodes
+// and printSignatures), the evaluations and the class summaries for previous
+// years. Archives with the legacy
+// participants blob are restored as participant chunks. The data for each item is the decoded value as JSON
+// with the schema version for the item kind, see encoding.go. The checksum in the manifest is the SHA-256 of
+// the compacted JSON data. Blob history and the audit log are not included.
+
+const (
+	backupFormat        = "seaptc-backup"
+	backupFormatVersion = 1
+)
+
+type backupArchive struct {
+	Format        string                `json:"format"`
+	FormatVersion int                   `json:"formatVersion"`
+	Created       time.Time             `json:"created"`
+	MetaVersion   int64                 `json:"metaVersion"`
+	Manifest      []*backupManifestItem `json:"manifest"`
+	Items         []*backupItem         `json:"items"`
+}
+
+type backupManifestItem struct {
+	Kind    string `json:"kind"`
+	Name    string `json:"name"`
+	Version int64  `json:"version,omitempty"`
+	SHA256  string `json:"sha256"`
+}
+
+type backupItem struct {
+	Kind    string          `json:"kind"`
+	Name    string          `json:"name"`
+	Version int64           `json:"version,omitempty"`
+	Schema  int             `json:"schema,omitempty"`
+	Data    json.RawMessage `json:"data"`
+}
+
+// blobValue returns a pointer to a value for decoding the named blob or
+// evaluation.
+func blobValue(kind string) (interface{}, error) {
+	switch kind {
+	case configurationKey.Name:
+		return &conference.Configuration{}, nil
+	case classesKey.Name:
+		return &[]*conference.Class{}, nil
+	case participantsKey.Name:
+		return &[]*conference.Participant{}, nil
+	case instructorClassesKey.Name:
+		return &map[string][]int{}, nil
+	case redirectsKey.Name, loginCodesKey.Name, printSignaturesKey.Name:
+		return &map[string]string{}, nil
+	case participantOverridesKey.Name:
+		return &map[string]*conference.ParticipantOverride{}, nil
+	case evalKind:
+		return &conference.Evaluation{}, nil
+	case classYearsKind:
+		return &[]*conference.ClassYear{}, nil
+	default:
+		return nil, fmt.Errorf("store: unknown blob name %q", kind)
+	}
+}
+
+// blobJSON returns the stored data for the named blob or evaluation as JSON
+// with the current schema.
+func blobJSON(name string, data []byte) (json.RawMessage, error) {
+	kind := blobKind(name)
+	if kind == configurationKey.Name {
+		var buf bytes.Buffer
+		if err := json.Compact(&buf, data); err != nil {
+			return nil, fmt.Errorf("store.%s: %w", kind, err)
+		}
+		return buf.Bytes(), nil
+	}
+	v, err := blobValue(kind)
+	if err != nil {
+		return nil, err
+	}
+	if err := decodeBlob(kind, data, v); err != nil {
+		return nil, err
+	}
+	return json.Marshal(v)
+}
+
+// blobFromJSON returns the data to store for the named blob or evaluation
+// from JSON with the given schema version.
+func blobFromJSON(name string, schema int, p json.RawMessage) ([]byte, error) {
+	kind := blobKind(name)
+	v, err := blobValue(kind)
+	if err != nil {
+		return nil, err
+	}
+	if kind != configurationKey.Name {
+		p, err = migrateJSON(kind, schema, p)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if err := json.Unmarshal(p, v); err != nil {
+		return nil, fmt.Errorf("store.%s: %w", kind, err)
+	}
+	if kind == configurationKey.Name {
+		return json.Marshal(v)
+	}
+	return encodeBlob(kind, v)
+}
+
+// schemaVersion returns the current schema version for the named blob or
+// evaluation or zero if the data is not stored in an envelope.
+func schemaVersion(name string) int {
+	if schema := blobSchemas[blobKind(name)]; schema != nil {
+		return schema.version
+	}
+	return 0
+}
+
+func backupChecksum(p []byte) (string, error) {
+	var buf bytes.Buffer
+	if err := json.Compact(&buf, p); err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(buf.Bytes())
+	return hex.EncodeToString(sum[:]), nil
+}
+
+// backupBlobNames is the list of blobs included in a backup.
+var backupBlobNames = append([]string{
+	configurationKey.Name,
+	classesKey.Name,
+	participantOverridesKey.Name,
+	instructorClassesKey.Name,
+	redirectsKey.Name,
+	loginCodesKey.Name,
+	printSignaturesKey.Name,
+}, participantChunkNames()...)
+
+// Backup writes all blobs and evaluations to w as a backup archive.
+func (s *Store) Backup(ctx context.Context, w io.Writer) error {
+	archive := &backupArchive{
+		Format:        backupFormat,
+		FormatVersion: backupFormatVersion,
+		Created:       time.Now().UTC(),
+	}
+
+	var m metaEntity
+	if err := noEntityOK(s.client.Get(ctx, metaKey, &m)); err != nil {
+		return err
+	}
+	archive.MetaVersion = m.Version
+
+	keys := make([]*datastore.Key, len(backupBlobNames))
+	for i, name := range backupBlobNames {
+		keys[i] = blobKey(name)
+	}
+	blobs := make([]blobEntity, len(keys))
+	err := s.client.GetMulti(ctx, keys, blobs)
+	if errs, ok := err.(datastore.MultiError); ok {
+		for i, err := range errs {
+			if err == datastore.ErrNoSuchEntity {
+				keys[i] = nil
+			}
+		}
+	}
+	if err := noEntityOK(err); err != nil {
+		return err
+	}
+	for i, b := range blobs {
+		if keys[i] == nil {
+			continue
+		}
+		p, err := blobJSON(keys[i].Name, b.Data)
+		if err != nil {
+			return err
+		}
+		archive.Items = append(archive.Items, &backupItem{
+			Kind:    "blob",
+			Name:    keys[i].Name,
+			Version: b.Version,
+			Schema:  schemaVersion(keys[i].Name),
+			Data:    p,
+		})
+	}
+
+	var evals []blobEntity
+	evalKeys, err := s.client.GetAll(ctx, datastore.NewQuery("eval").Ancestor(conferenceEntityGroupKey), &evals)
+	if err != nil {
+		return err
+	}
+	for i, b := range evals {
+		p, err := blobJSON(evalKind, b.Data)
+		if err != nil {
+			return fmt.Errorf("%w (participant %s)", err, evalKeys[i].Name)
+		}
+		archive.Items = append(archive.Items, &backupItem{
+			Kind:   evalKind,
+			Name:   evalKeys[i].Name,
+			Schema: schemaVersion(evalKind),
+			Data:   p,
+		})
+	}
+
+	var classYears []classYearsEntity
+	classYearsKeys, err := s.client.GetAll(ctx, datastore.NewQuery(classYearsKind).Ancestor(conferenceEntityGroupKey), &classYears)
+	if err != nil {
+		return err
+	}
+	for i, e := range classYears {
+		p, err := blobJSON(classYearsKind, e.Data)
+		if err != nil {
+			return fmt.Errorf("%w (year %d)", err, e.Year)
+		}
+		archive.Items = append(archive.Items, &backupItem{
+			Kind:   classYearsKind,
+			Name:   classYearsKeys[i].Name,
+			Schema: schemaVersion(classYearsKind),
+			Data:   p,
+		})
+	}
+
+	for _, item := range archive.Items {
+		sum, err := backupChecksum(item.Data)
+		if err != nil {
+			return err
+		}
+		archive.Manifest = append(archive.Manifest, &backupManifestItem{
+			Kind:    item.Kind,
+			Name:    item.Name,
+			Version: item.Version,
+			SHA256:  sum,
+		})
+	}
+
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(archive)
+}
+
+// readBackup reads and verifies a backup archive.
+func readBackup(r io.Reader) (*backupArchive, error) {
+	var archive backupArchive
+	if err := json.NewDecoder(r).Decode(&archive); err != nil {
+		return nil, fmt.Errorf("store: error reading backup: %w", err)
+	}
+	if archive.Format != backupFormat {
+		return nil, fmt.Errorf("store: not a backup archive, format is %q", archive.Format)
+	}
+	if archive.FormatVersion != backupFormatVersion {
+		return nil, fmt.Errorf("store: unsupported backup format version %d", archive.FormatVersion)
+	}
+	if len(archive.Manifest) != len(archive.Items) {
+		return nil, fmt.Errorf("store: backup manifest has %d items, archive has %d", len(archive.Manifest), len(archive.Items))
+	}
+	for i, item := range archive.Items {
+		mi := archive.Manifest[i]
+		if mi.Kind != item.Kind || mi.Name != item.Name || mi.Version != item.Version {
+			return nil, fmt.Errorf("store: backup item %s %s does not match manifest", item.Kind, item.Name)
+		}
+		sum, err := backupChecksum(item.Data)
+		if err != nil {
+			return nil, fmt.Errorf("store: backup item %s %s: %w", item.Kind, item.Name, err)
+		}
+		if sum != mi.SHA256 {
+			return nil, fmt.Errorf("store: checksum mismatch for backup item %s %s", item.Kind, item.Name)
+		}
+	}
+	return &archive, nil
+}
+
+// ErrStoreNotEmpty is returned by Restore when the store has data and force
+// is not set.
+var ErrStoreNotEmpty = errors.New("store: store is not empty")
+
+// Restore loads a backup archive written by Backup. If the store has data
+// and force is false, ErrStoreNotEmpty is returned. When force is true,
+// blobs, evaluations and class summaries not in the archive are deleted.
+//
+// The restore is not atomic. The archive is decoded and validated before
+// anything is written, but evaluations are written in batches before the
+// blobs are written in a single transaction. If Restore fails partway
+// through, the store can have evaluations from the archive mixed with the
+// current blobs. Run Restore again with force to complete the restore.
+func (s *Store) Restore(ctx context.Context, r io.Reader, force bool) error {
+	archive, err := readBackup(r)
+	if err != nil {
+		return err
+	}
+
+	blobData := make(map[string][]byte)
+	evals := make(map[string][]byte)
+	classYears := make(map[int][]byte)
+	for _, item := range archive.Items {
+		// Archives written before schema versions were recorded have
+		// schema 1 data.
+		schema := item.Schema
+		if schema == 0 {
+			schema = 1
+		}
+		switch item.Kind {
+		case "blob":
+			data, err := blobFromJSON(item.Name, schema, item.Data)
+			if err != nil {
+				return err
+			}
+			blobData[item.Name] = data
+		case evalKind:
+			data, err := blobFromJSON(evalKind, schema, item.Data)
+			if err != nil {
+				return fmt.Errorf("%w (participant %s)", err, item.Name)
+			}
+			evals[item.Name] = data
+		case classYearsKind:
+			year, err := strconv.Atoi(item.Name)
+			if err != nil {
+				return fmt.Errorf("store: bad class summary year %q", item.Name)
+			}
+			data, err := blobFromJSON(classYearsKind, schema, item.Data)
+			if err != nil {
+				return fmt.Errorf("%w (year %d)", err, year)
+			}
+			classYears[year] = data
+		default:
+			return fmt.Errorf("store: unknown backup item kind %q", item.Kind)
+		}
+	}
+
+	if err := splitLegacyParticipants(blobData); err != nil {
+		return err
+	}
+
+	existingBlobs, err := s.client.GetAll(ctx, datastore.NewQuery("blob").Ancestor(conferenceEntityGroupKey).KeysOnly(), nil)
+	if err != nil {
+		return err
+	}
+	existingEvals, err := s.client.GetAll(ctx, datastore.NewQuery("eval").Ancestor(conferenceEntityGroupKey).KeysOnly(), nil)
+	if err != nil {
+		return err
+	}
+	existingClassYears, err := s.client.GetAll(ctx, datastore.NewQuery(classYearsKind).Ancestor(conferenceEntityGroupKey).KeysOnly(), nil)
+	if err != nil {
+		return err
+	}
+	if (len(existingBlobs) > 0 || len(existingEvals) > 0 || len(existingClassYears) > 0) && !force {
+		return ErrStoreNotEmpty
+	}
+
+	// Evaluations are written outside of the blob transaction to stay
+	// within the transaction entity limit. See the note on atomicity above.
+	var deleteKeys []*datastore.Key
+	for _, k := range existingEvals {
+		if _, ok := evals[k.Name]; !ok {
+			deleteKeys = append(deleteKeys, k)
+		}
+	}
+	for _, k := range existingBlobs {
+		if _, ok := blobData[k.Name]; !ok {
+			deleteKeys = append(deleteKeys, k)
+		}
+	}
+	for _, k := range existingClassYears {
+		if year, _ := strconv.Atoi(k.Name); classYears[year] == nil {
+			deleteKeys = append(deleteKeys, k)
+		}
+	}
+	const batchSize = 500
+	for len(deleteKeys) > 0 {
+		n := len(deleteKeys)
+		if n > batchSize {
+			n = batchSize
+		}
+		if err := s.client.DeleteMulti(ctx, deleteKeys[:n]); err != nil {
+			return err
+		}
+		deleteKeys = deleteKeys[n:]
+	}
+
+	var keys []*datastore.Key
+	var entities []*blobEntity
+	for id, data := range evals {
+		keys = append(keys, evaluationKey(id))
+		entities = append(entities, &blobEntity{Data: data})
+	}
+	for len(keys) > 0 {
+		n := len(keys)
+		if n > batchSize {
+			n = batchSize
+		}
+		if _, err := s.client.PutMulti(ctx, keys[:n], entities[:n]); err != nil {
+			return err
+		}
+		keys, entities = keys[n:], entities[n:]
+	}
+
+	var version int64
+	_, err = s.client.RunInTransaction(ctx, func(tx *datastore.Transaction) error {
+		var m metaEntity
+		err := noEntityOK(tx.Get(metaKey, &m))
+		if err != nil {
+			return err
+		}
+		if archive.MetaVersion > m.Version {
+			m.Version = archive.MetaVersion
+		}
+		// Use a new version so that running instances reload all blobs.
+		m.Version += 1
+		version = m.Version
+
+		for name, data := range blobData {
+			if _, ok := blobUpdaters[name]; ok || isParticipantBlob(name) {
+				err = s.putVersionedBlob(ctx, tx, blobKey(name), m.Version, data)
+			} else {
+				_, err = tx.Put(blobKey(name), &blobEntity{Data: data})
+			}
+			if err != nil {
+				return err
+			}
+		}
+		// There is one class summary entity per year, so the summaries
+		// fit in the blob transaction.
+		for year, data := range classYears {
+			if _, err := tx.Put(classYearsKey(year), &classYearsEntity{Year: year, Data: data}); err != nil {
+				return err
+			}
+		}
+		_, err = tx.Put(metaKey, &m)
+		if err != nil {
+			return err
+		}
+		return putAudit(ctx, tx, "restoreBackup", "",
+			fmt.Sprintf("restored %d blobs, %d evaluations and %d class summary years from backup created %s",
+				len(blobData), len(evals), len(classYears), archive.Created.Format(time.RFC3339)))
+	})
+	if err == nil {
+		s.publish(ctx, version)
+	}
+	return err
+}
+
+// splitLegacyParticipants replaces the legacy participants blob with
+// participant chunks. All chunks are stored so that a restore replaces every
+// chunk loaded by running instances.
+func splitLegacyParticipants(blobData map[string][]byte) error {
+	var participants []*conference.Participant
+	if data, ok := blobData[participantsKey.Name]; ok {
+		err := decodeBlob(participantsKey.Name, data, &participants)
+		if err != nil {
+			return err
+		}
+		delete(blobData, participantsKey.Name)
+	} else {
+		found := false
+		for _, name := range participantChunkNames() {
+			if _, ok := blobData[name]; ok {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return nil
+		}
+	}
+	chunks := splitParticipants(participants)
+	for i, name := range participantChunkNames() {
+		if _, ok := blobData[name]; ok {
+			continue
+		}
+		data, err := encodeBlob(participantsKey.Name, chunks[i])
+		if err != nil {
+			return err
+		}
+		blobData[name] = data
+	}
+	return nil
+}
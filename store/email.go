@@ -0,0 +1,111 @@
+package store
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"cloud.google.com/go/datastore"
+)
+
+// Email log entries and unsubscribed addresses are root entities, not in the
+// conference entity group, so that sending email does not contend with
+// conference writes.
+
+// EmailLogEntry records a message sent to an address in a campaign. There is
+// at most one entry per conference year, campaign and address.
+type EmailLogEntry struct {
+	Year     int
+	Campaign string
+	Address  string
+	Time     time.Time
+	Subject  string `datastore:",noindex"`
+	Error    string `datastore:",noindex"` // empty if the message was sent
+	Sending  bool   `datastore:",noindex"` // true from claim until the result is stored
+}
+
+// Sent returns true if the message was sent without error.
+func (e *EmailLogEntry) Sent() bool {
+	return !e.Sending && e.Error == ""
+}
+
+func emailLogKey(year int, campaign, address string) *datastore.Key {
+	return datastore.NameKey("emailLog", fmt.Sprintf("%d/%s/%s", year, campaign, strings.ToLower(address)), nil)
+}
+
+// GetEmailLog returns the log entries for a campaign in the conference year.
+func (s *Store) GetEmailLog(ctx context.Context, year int, campaign string) ([]*EmailLogEntry, error) {
+	var entries []*EmailLogEntry
+	_, err := s.client.GetAll(ctx,
+		datastore.NewQuery("emailLog").Filter("Year =", year).Filter("Campaign =", campaign),
+		&entries)
+	return entries, err
+}
+
+// ClaimEmailLogEntry stores e marked as sending if there is no entry for the
+// year, campaign and address or if the previous message failed. It returns
+// false if the message was sent or is being sent by another request. After
+// sending, store the result with PutEmailLogEntry. An entry left sending by a
+// request that failed is not claimed again because the message may have been
+// sent.
+func (s *Store) ClaimEmailLogEntry(ctx context.Context, e *EmailLogEntry) (bool, error) {
+	key := emailLogKey(e.Year, e.Campaign, e.Address)
+	var claimed bool
+	_, err := s.client.RunInTransaction(ctx, func(tx *datastore.Transaction) error {
+		claimed = false
+		var previous EmailLogEntry
+		err := tx.Get(key, &previous)
+		if err == nil && previous.Error == "" {
+			// Sent or sending.
+			return nil
+		}
+		if err := noEntityOK(err); err != nil {
+			return err
+		}
+		claim := *e
+		claim.Sending = true
+		claim.Error = ""
+		if _, err := tx.Put(key, &claim); err != nil {
+			return err
+		}
+		claimed = true
+		return nil
+	})
+	return claimed && err == nil, err
+}
+
+// PutEmailLogEntry stores the entry, replacing a previous entry for the
+// year, campaign and address.
+func (s *Store) PutEmailLogEntry(ctx context.Context, e *EmailLogEntry) error {
+	_, err := s.client.Put(ctx, emailLogKey(e.Year, e.Campaign, e.Address), e)
+	return err
+}
+
+type unsubscribeEntity struct {
+	Address string
+	Time    time.Time
+}
+
+func unsubscribeKey(address string) *datastore.Key {
+	return datastore.NameKey("unsubscribe", strings.ToLower(address), nil)
+}
+
+// Unsubscribe records that address does not want campaign email.
+func (s *Store) Unsubscribe(ctx context.Context, address string) error {
+	_, err := s.client.Put(ctx, unsubscribeKey(address), &unsubscribeEntity{Address: strings.ToLower(address), Time: time.Now()})
+	return err
+}
+
+// GetUnsubscribed returns the set of lower case unsubscribed addresses.
+func (s *Store) GetUnsubscribed(ctx context.Context) (map[string]bool, error) {
+	keys, err := s.client.GetAll(ctx, datastore.NewQuery("unsubscribe").KeysOnly(), nil)
+	if err != nil {
+		return nil, err
+	}
+	result := make(map[string]bool, len(keys))
+	for _, k := range keys {
+		result[k.Name] = true
+	}
+	return result, nil
+}
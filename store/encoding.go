@@ -0,0 +1,272 @@
+package store
+
+import (
+	"bytes"
+	"context"
+	"encoding/gob"
+	"encoding/json"
+	"fmt"
+	"sort"
+
+	"cloud.google.com/go/datastore"
+)
+
+// Blobs and evaluations are stored in a JSON envelope:
+//
+//  {"format":"seaptc","kind":"classes","schema":1,"data":...}
+//
+// Kind is the blob name, "eval" or "classYears". Schema is the version of the data schema
+// for the kind. When the schema changes, the schema version is incremented
+// and a migration function from the previous version is added to the kind's
+// migrations. Data stored with an older schema is migrated when read.
+//
+// Data stored before the envelope was introduced is Gob encoded. A Gob
+// stream starts with a type definition and never starts with the envelope
+// prefix. Legacy data is decoded with Gob; ptctool migrate rewrites legacy
+// data in the envelope format.
+//
+// The configuration blob is plain JSON and does not use an envelope. The
+// participant chunks use the kind of the legacy participants blob, see
+// blobKind.
+
+const envelopeFormat = "seaptc"
+
+var envelopePrefix = []byte(`{"format":"` + envelopeFormat + `"`)
+
+type envelope struct {
+	Format string          `json:"format"`
+	Kind   string          `json:"kind"`
+	Schema int             `json:"schema"`
+	Data   json.RawMessage `json:"data"`
+}
+
+// blobSchema describes the current schema for a kind of data.
+type blobSchema struct {
+	version int
+
+	// migrations[i] converts data from schema version i+1 to i+2.
+	migrations []func(json.RawMessage) (json.RawMessage, error)
+}
+
+const (
+	evalKind       = "eval"
+	classYearsKind = "classYears"
+)
+
+var blobSchemas = map[string]*blobSchema{
+	classesKey.Name:              {version: 1},
+	participantsKey.Name:         {version: 1},
+	instructorClassesKey.Name:    {version: 1},
+	redirectsKey.Name:            {version: 1},
+	loginCodesKey.Name:           {version: 1},
+	printSignaturesKey.Name:      {version: 1},
+	participantOverridesKey.Name: {version: 1},
+	classYearsKind:               {version: 1},
+	evalKind: {
+		version: 2,
+		migrations: []func(json.RawMessage) (json.RawMessage, error){
+			migrateEvalPresentation,
+		},
+	},
+}
+
+// blobKind returns the kind of data stored in the named blob.
+func blobKind(name string) string {
+	if _, ok := participantChunkIndex(name); ok {
+		return participantsKey.Name
+	}
+	return name
+}
+
+// isLegacyEncoding returns true if data is Gob encoded.
+func isLegacyEncoding(data []byte) bool {
+	return len(data) > 0 && !bytes.HasPrefix(data, envelopePrefix)
+}
+
+// encodeBlob encodes v in an envelope with the current schema version for
+// kind.
+func encodeBlob(kind string, v interface{}) ([]byte, error) {
+	schema := blobSchemas[kind]
+	if schema == nil {
+		return nil, fmt.Errorf("store: unknown kind %q", kind)
+	}
+	p, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(&envelope{
+		Format: envelopeFormat,
+		Kind:   kind,
+		Schema: schema.version,
+		Data:   p,
+	})
+}
+
+// decodeBlob decodes data written by encodeBlob or legacy Gob encoded data
+// into v. Empty data is ignored.
+func decodeBlob(kind string, data []byte, v interface{}) error {
+	if len(data) == 0 {
+		return nil
+	}
+	if isLegacyEncoding(data) {
+		if err := gob.NewDecoder(bytes.NewReader(data)).Decode(v); err != nil {
+			return fmt.Errorf("store.%s: %w", kind, err)
+		}
+		return nil
+	}
+	var env envelope
+	if err := json.Unmarshal(data, &env); err != nil {
+		return fmt.Errorf("store.%s: %w", kind, err)
+	}
+	if env.Kind != kind {
+		return fmt.Errorf("store.%s: data has kind %q", kind, env.Kind)
+	}
+	p, err := migrateJSON(kind, env.Schema, env.Data)
+	if err != nil {
+		return err
+	}
+	if err := json.Unmarshal(p, v); err != nil {
+		return fmt.Errorf("store.%s: %w", kind, err)
+	}
+	return nil
+}
+
+// migrateJSON converts data with the given schema version to the current
+// schema version for kind.
+func migrateJSON(kind string, version int, p json.RawMessage) (json.RawMessage, error) {
+	schema := blobSchemas[kind]
+	if schema == nil {
+		return nil, fmt.Errorf("store: unknown kind %q", kind)
+	}
+	if version < 1 || version > schema.version {
+		return nil, fmt.Errorf("store.%s: unsupported schema version %d", kind, version)
+	}
+	for ; version < schema.version; version++ {
+		var err error
+		p, err = schema.migrations[version-1](p)
+		if err != nil {
+			return nil, fmt.Errorf("store.%s: migrate schema %d: %w", kind, version, err)
+		}
+	}
+	return p, nil
+}
+
+// migrateEvalPresentation renames the session evaluation "promotion" field
+// to "presentation". Schema 1 used the name of the conference promotion
+// rating for the session presentation rating.
+func migrateEvalPresentation(p json.RawMessage) (json.RawMessage, error) {
+	var eval map[string]json.RawMessage
+	if err := json.Unmarshal(p, &eval); err != nil {
+		return nil, err
+	}
+	var sessions []map[string]json.RawMessage
+	if s, ok := eval["sessions"]; ok {
+		if err := json.Unmarshal(s, &sessions); err != nil {
+			return nil, err
+		}
+	}
+	for _, s := range sessions {
+		if v, ok := s["promotion"]; ok {
+			s["presentation"] = v
+			delete(s, "promotion")
+		}
+	}
+	s, err := json.Marshal(sessions)
+	if err != nil {
+		return nil, err
+	}
+	eval["sessions"] = s
+	return json.Marshal(eval)
+}
+
+// needsMigration returns true if data is Gob encoded or has an old schema
+// version.
+func needsMigration(kind string, data []byte) bool {
+	if len(data) == 0 {
+		return false
+	}
+	if isLegacyEncoding(data) {
+		return true
+	}
+	var env struct {
+		Schema int `json:"schema"`
+	}
+	if err := json.Unmarshal(data, &env); err != nil {
+		return false
+	}
+	schema := blobSchemas[kind]
+	return schema != nil && env.Schema < schema.version
+}
+
+// MigrateEncoding rewrites blobs and evaluations stored with Gob or an old
+// schema version in the current format. If dryRun is true, the data is
+// checked but not written. The names of the migrated blobs and the number of
+// migrated evaluations are returned. Blob history is not rewritten; old
+// versions are decoded as needed.
+func (s *Store) MigrateEncoding(ctx context.Context, dryRun bool) (blobNames []string, evalCount int, err error) {
+	migrate := func(key *datastore.Key, kind string) (bool, error) {
+		migrated := false
+		_, err := s.client.RunInTransaction(ctx, func(tx *datastore.Transaction) error {
+			migrated = false
+			var blob blobEntity
+			err := noEntityOK(tx.Get(key, &blob))
+			if err != nil || !needsMigration(kind, blob.Data) {
+				return err
+			}
+			v, err := blobValue(kind)
+			if err != nil {
+				return err
+			}
+			if err := decodeBlob(kind, blob.Data, v); err != nil {
+				return err
+			}
+			data, err := encodeBlob(kind, v)
+			if err != nil {
+				return err
+			}
+			migrated = true
+			if dryRun {
+				return nil
+			}
+			// The version is not changed because the decoded value
+			// is the same.
+			_, err = tx.Put(key, &blobEntity{Version: blob.Version, Data: data})
+			return err
+		})
+		return migrated, err
+	}
+
+	// Class summaries are only stored with an envelope and have one
+	// schema version, so they are not migrated.
+	names := participantChunkNames()
+	for name := range blobSchemas {
+		if name != evalKind && name != classYearsKind {
+			names = append(names, name)
+		}
+	}
+	for _, name := range names {
+		migrated, err := migrate(blobKey(name), blobKind(name))
+		if err != nil {
+			return nil, 0, err
+		}
+		if migrated {
+			blobNames = append(blobNames, name)
+		}
+	}
+	sort.Strings(blobNames)
+
+	keys, err := s.client.GetAll(ctx, datastore.NewQuery("eval").Ancestor(conferenceEntityGroupKey).KeysOnly(), nil)
+	if err != nil {
+		return nil, 0, err
+	}
+	for _, key := range keys {
+		migrated, err := migrate(key, evalKind)
+		if err != nil {
+			return nil, 0, fmt.Errorf("%w (participant %s)", err, key.Name)
+		}
+		if migrated {
+			evalCount++
+		}
+	}
+	return blobNames, evalCount, nil
+}
@@ -0,0 +1,141 @@
+package store
+
+import (
+	"context"
+	"fmt"
+	"mime"
+	"sort"
+	"time"
+
+	"cloud.google.com/go/datastore"
+)
+
+// Handouts are stored in the conference entity group. The handout entity has
+// the metadata used to list handouts and the handoutData entity with the same
+// key name has the file contents.
+
+// MaxHandoutSize is the maximum size of a handout file. Datastore entities are
+// limited to 1 MiB.
+const MaxHandoutSize = 1000 * 1000
+
+// Handout is a file uploaded by an instructor for a class.
+type Handout struct {
+	Class       int
+	Name        string
+	ContentType string    `datastore:",noindex"`
+	Size        int       `datastore:",noindex"`
+	Uploaded    time.Time `datastore:",noindex"`
+	UploadedBy  string    `datastore:",noindex"`
+}
+
+// handoutContentTypes are the content types that handouts are served with.
+// Other files are served as application/octet-stream.
+var handoutContentTypes = map[string]bool{
+	"application/msword":            true,
+	"application/pdf":               true,
+	"application/vnd.ms-excel":      true,
+	"application/vnd.ms-powerpoint": true,
+	"application/vnd.openxmlformats-officedocument.presentationml.presentation": true,
+	"application/vnd.openxmlformats-officedocument.spreadsheetml.sheet":         true,
+	"application/vnd.openxmlformats-officedocument.wordprocessingml.document":   true,
+	"application/zip": true,
+	"image/gif":       true,
+	"image/jpeg":      true,
+	"image/png":       true,
+	"text/csv":        true,
+	"text/plain":      true,
+}
+
+// ServeContentType returns the content type to use when serving the handout.
+// The content type supplied by the uploader is used only if it is a known
+// document type.
+func (h *Handout) ServeContentType() string {
+	mt, _, err := mime.ParseMediaType(h.ContentType)
+	if err != nil || !handoutContentTypes[mt] {
+		return "application/octet-stream"
+	}
+	return mt
+}
+
+type handoutDataEntity struct {
+	Data []byte `datastore:",noindex"`
+}
+
+func handoutKeyName(class int, name string) string {
+	return fmt.Sprintf("%d/%s", class, name)
+}
+
+func handoutKey(class int, name string) *datastore.Key {
+	return datastore.NameKey("handout", handoutKeyName(class, name), conferenceEntityGroupKey)
+}
+
+func handoutDataKey(class int, name string) *datastore.Key {
+	return datastore.NameKey("handoutData", handoutKeyName(class, name), conferenceEntityGroupKey)
+}
+
+// GetHandouts returns the handouts for all classes by class number. The
+// handouts for each class are sorted by name.
+func (s *Store) GetHandouts(ctx context.Context) (map[int][]*Handout, error) {
+	var handouts []*Handout
+	_, err := s.client.GetAll(ctx, datastore.NewQuery("handout").Ancestor(conferenceEntityGroupKey), &handouts)
+	if err != nil {
+		return nil, err
+	}
+	result := make(map[int][]*Handout)
+	for _, h := range handouts {
+		result[h.Class] = append(result[h.Class], h)
+	}
+	for _, handouts := range result {
+		sort.Slice(handouts, func(i, j int) bool { return handouts[i].Name < handouts[j].Name })
+	}
+	return result, nil
+}
+
+// GetHandout returns the handout and its contents. Nil is returned if the
+// handout does not exist.
+func (s *Store) GetHandout(ctx context.Context, class int, name string) (*Handout, []byte, error) {
+	var h Handout
+	var d handoutDataEntity
+	err := s.client.GetMulti(ctx,
+		[]*datastore.Key{handoutKey(class, name), handoutDataKey(class, name)},
+		[]interface{}{&h, &d})
+	if errs, ok := err.(datastore.MultiError); ok && (errs[0] == datastore.ErrNoSuchEntity || errs[1] == datastore.ErrNoSuchEntity) {
+		return nil, nil, nil
+	} else if err != nil {
+		return nil, nil, err
+	}
+	return &h, d.Data, nil
+}
+
+// PutHandout stores a handout, replacing the handout with the same class and
+// name.
+func (s *Store) PutHandout(ctx context.Context, h *Handout, data []byte) error {
+	if len(data) > MaxHandoutSize {
+		return fmt.Errorf("store: handout %s is larger than %d bytes", h.Name, MaxHandoutSize)
+	}
+	h.Size = len(data)
+	h.Uploaded = time.Now()
+	h.UploadedBy = Actor(ctx)
+	_, err := s.client.RunInTransaction(ctx, func(tx *datastore.Transaction) error {
+		_, err := tx.PutMulti(
+			[]*datastore.Key{handoutKey(h.Class, h.Name), handoutDataKey(h.Class, h.Name)},
+			[]interface{}{h, &handoutDataEntity{Data: data}})
+		if err != nil {
+			return err
+		}
+		return putAudit(ctx, tx, "putHandout", handoutKeyName(h.Class, h.Name), fmt.Sprintf("%d bytes", len(data)))
+	})
+	return err
+}
+
+// DeleteHandout deletes a handout.
+func (s *Store) DeleteHandout(ctx context.Context, class int, name string) error {
+	_, err := s.client.RunInTransaction(ctx, func(tx *datastore.Transaction) error {
+		err := noEntityOK(tx.DeleteMulti([]*datastore.Key{handoutKey(class, name), handoutDataKey(class, name)}))
+		if err != nil {
+			return err
+		}
+		return putAudit(ctx, tx, "deleteHandout", handoutKeyName(class, name), "deleted")
+	})
+	return err
+}
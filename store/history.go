@@ -0,0 +1,337 @@
+package store
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"cloud.google.com/go/datastore"
+)
+
+// historySize is the number of versions kept for each versioned blob.
+const historySize = 10
+
+// BlobVersion is a saved version of a blob. Versions are stored as children
+// of the blob entity with the version as the key ID.
+type BlobVersion struct {
+	Version int64
+	Time    time.Time
+	Actor   string
+	Data    []byte `datastore:",noindex"`
+}
+
+// HistoryBlobNames is the list of blobs with version history.
+//
+// Participants are stored in chunks and each chunk has its own history. A
+// chunk is versioned only when its participants change, and the history of
+// each chunk is trimmed separately. Restoring a chunk restores the
+// participants in that chunk only. There is no restore of all participants
+// to a single point in time; use a backup archive for that.
+var HistoryBlobNames = append([]string{
+	configurationKey.Name,
+	classesKey.Name,
+	participantOverridesKey.Name,
+	instructorClassesKey.Name,
+	redirectsKey.Name,
+}, participantChunkNames()...)
+
+func blobVersionKey(name string, version int64) *datastore.Key {
+	return datastore.IDKey("blobVersion", version, blobKey(name))
+}
+
+// putVersionedBlob puts the blob and a copy in the blob's version history.
+// Versions older than the last historySize versions are deleted.
+func (s *Store) putVersionedBlob(ctx context.Context, tx *datastore.Transaction, key *datastore.Key, version int64, data []byte) error {
+	if _, err := tx.Put(key, &blobEntity{Version: version, Data: data}); err != nil {
+		return err
+	}
+	_, err := tx.Put(blobVersionKey(key.Name, version), &BlobVersion{
+		Version: version,
+		Time:    time.Now(),
+		Actor:   Actor(ctx),
+		Data:    data,
+	})
+	if err != nil {
+		return err
+	}
+
+	// Keys are ordered by version. The query does not see the put above,
+	// so keep historySize-1 of the existing versions.
+	q := datastore.NewQuery("blobVersion").Ancestor(blobKey(key.Name)).KeysOnly().Transaction(tx)
+	keys, err := s.client.GetAll(ctx, q, nil)
+	if err != nil {
+		return err
+	}
+	if n := len(keys) - (historySize - 1); n > 0 {
+		return tx.DeleteMulti(keys[:n])
+	}
+	return nil
+}
+
+func checkHistoryBlobName(name string) error {
+	for _, n := range HistoryBlobNames {
+		if n == name {
+			return nil
+		}
+	}
+	return fmt.Errorf("store: blob %q does not have history", name)
+}
+
+// BlobHistory returns the saved versions of the named blob, newest first.
+// If the current blob was saved before history was kept, the current blob is
+// included as a version without time and actor.
+func (s *Store) BlobHistory(ctx context.Context, name string) ([]*BlobVersion, error) {
+	if err := checkHistoryBlobName(name); err != nil {
+		return nil, err
+	}
+	var versions []*BlobVersion
+	_, err := s.client.GetAll(ctx, datastore.NewQuery("blobVersion").Ancestor(blobKey(name)), &versions)
+	if err != nil {
+		return nil, err
+	}
+	for i, j := 0, len(versions)-1; i < j; i, j = i+1, j-1 {
+		versions[i], versions[j] = versions[j], versions[i]
+	}
+
+	var blob blobEntity
+	err = noEntityOK(s.client.Get(ctx, blobKey(name), &blob))
+	if err != nil {
+		return nil, err
+	}
+	if blob.Version > 0 && (len(versions) == 0 || versions[0].Version != blob.Version) {
+		versions = append([]*BlobVersion{{Version: blob.Version, Data: blob.Data}}, versions...)
+	}
+	return versions, nil
+}
+
+// GetBlobVersion returns the given version of the named blob.
+func (s *Store) GetBlobVersion(ctx context.Context, name string, version int64) (*BlobVersion, error) {
+	if err := checkHistoryBlobName(name); err != nil {
+		return nil, err
+	}
+	if version <= 0 {
+		return nil, fmt.Errorf("store: bad version %d", version)
+	}
+	var v BlobVersion
+	err := s.client.Get(ctx, blobVersionKey(name, version), &v)
+	if err == datastore.ErrNoSuchEntity {
+		var blob blobEntity
+		err = s.client.Get(ctx, blobKey(name), &blob)
+		if err == nil && blob.Version != version {
+			err = datastore.ErrNoSuchEntity
+		}
+		v = BlobVersion{Version: blob.Version, Data: blob.Data}
+	}
+	if err == datastore.ErrNoSuchEntity {
+		return nil, fmt.Errorf("store: version %d of %s not found", version, name)
+	} else if err != nil {
+		return nil, err
+	}
+	return &v, nil
+}
+
+// RestoreBlobVersion saves the given version of the named blob as a new
+// version.
+func (s *Store) RestoreBlobVersion(ctx context.Context, name string, version int64) error {
+	v, err := s.GetBlobVersion(ctx, name, version)
+	if err != nil {
+		return err
+	}
+	// Check that the data can be decoded before storing it.
+	if _, err := BlobText(name, v.Data); err != nil {
+		return err
+	}
+	var newVersion int64
+	_, err = s.client.RunInTransaction(ctx, func(tx *datastore.Transaction) error {
+		var m metaEntity
+		err := noEntityOK(tx.Get(metaKey, &m))
+		if err != nil {
+			return err
+		}
+		m.Version += 1
+		newVersion = m.Version
+		err = s.putVersionedBlob(ctx, tx, blobKey(name), m.Version, v.Data)
+		if err != nil {
+			return err
+		}
+		_, err = tx.Put(metaKey, &m)
+		if err != nil {
+			return err
+		}
+		return putAudit(ctx, tx, "restoreBlob", name, fmt.Sprintf("restored version %d as version %d", version, m.Version))
+	})
+	if err == nil {
+		s.publish(ctx, newVersion)
+	}
+	return err
+}
+
+// BlobText returns the blob data as indented JSON.
+func BlobText(name string, data []byte) (string, error) {
+	if err := checkHistoryBlobName(name); err != nil {
+		return "", err
+	}
+	p, err := blobJSON(name, data)
+	if err != nil {
+		return "", err
+	}
+	var buf bytes.Buffer
+	if err := json.Indent(&buf, p, "", "  "); err != nil {
+		return "", err
+	}
+	return buf.String(), nil
+}
+
+// DiffBlobVersions returns the differences between two versions of the named
+// blob as text.
+func (s *Store) DiffBlobVersions(ctx context.Context, name string, from, to int64) ([]*DiffLine, error) {
+	var texts [2]string
+	for i, version := range []int64{from, to} {
+		v, err := s.GetBlobVersion(ctx, name, version)
+		if err != nil {
+			return nil, err
+		}
+		texts[i], err = BlobText(name, v.Data)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return Diff(texts[0], texts[1]), nil
+}
+
+// DiffLine is a line in the output of Diff. Op is " " for unchanged lines,
+// "-" for removed lines, "+" for added lines and "@" for a gap between
+// changes.
+type DiffLine struct {
+	Op   string
+	Text string
+}
+
+func (l *DiffLine) String() string { return l.Op + " " + l.Text }
+
+// diffContext is the number of unchanged lines shown around changes.
+const diffContext = 3
+
+// Diff returns the line differences between a and b with a few lines of
+// context around each change.
+func Diff(a, b string) []*DiffLine {
+	lines := diffLines(strings.Split(a, "\n"), strings.Split(b, "\n"))
+
+	show := make([]bool, len(lines))
+	for i, l := range lines {
+		if l.Op == " " {
+			continue
+		}
+		for j := i - diffContext; j <= i+diffContext; j++ {
+			if j >= 0 && j < len(lines) {
+				show[j] = true
+			}
+		}
+	}
+
+	var result []*DiffLine
+	for i, l := range lines {
+		if !show[i] {
+			continue
+		}
+		if i > 0 && !show[i-1] && len(result) > 0 {
+			result = append(result, &DiffLine{Op: "@", Text: "..."})
+		}
+		result = append(result, l)
+	}
+	return result
+}
+
+// maxDiffEdits limits the work done by diffLines. If the inputs differ by
+// more than maxDiffEdits lines, all of a is shown as removed and all of b as
+// added.
+const maxDiffEdits = 2000
+
+// diffLines computes the shortest edit script using Myers' algorithm.
+func diffLines(a, b []string) []*DiffLine {
+	// Trim common prefix and suffix.
+	var prefix, suffix []*DiffLine
+	for len(a) > 0 && len(b) > 0 && a[0] == b[0] {
+		prefix = append(prefix, &DiffLine{Op: " ", Text: a[0]})
+		a, b = a[1:], b[1:]
+	}
+	for len(a) > 0 && len(b) > 0 && a[len(a)-1] == b[len(b)-1] {
+		suffix = append([]*DiffLine{{Op: " ", Text: a[len(a)-1]}}, suffix...)
+		a, b = a[:len(a)-1], b[:len(b)-1]
+	}
+
+	n, m := len(a), len(b)
+	offset := n + m + 1
+	v := make([]int, 2*offset+1)
+
+	// trace[d] is v[-d:d+1] at the start of step d.
+	var trace [][]int
+	found := false
+	for d := 0; d <= n+m && d <= maxDiffEdits && !found; d++ {
+		trace = append(trace, append([]int(nil), v[offset-d:offset+d+1]...))
+		for k := -d; k <= d; k += 2 {
+			var x int
+			if k == -d || (k != d && v[offset+k-1] < v[offset+k+1]) {
+				x = v[offset+k+1]
+			} else {
+				x = v[offset+k-1] + 1
+			}
+			y := x - k
+			for x < n && y < m && a[x] == b[y] {
+				x, y = x+1, y+1
+			}
+			v[offset+k] = x
+			if x >= n && y >= m {
+				found = true
+				break
+			}
+		}
+	}
+
+	var middle []*DiffLine
+	if !found {
+		for _, s := range a {
+			middle = append(middle, &DiffLine{Op: "-", Text: s})
+		}
+		for _, s := range b {
+			middle = append(middle, &DiffLine{Op: "+", Text: s})
+		}
+	} else {
+		x, y := n, m
+		for d := len(trace) - 1; d > 0; d-- {
+			tv := trace[d]
+			get := func(k int) int { return tv[k+d] }
+			k := x - y
+			var prevK int
+			if k == -d || (k != d && get(k-1) < get(k+1)) {
+				prevK = k + 1
+			} else {
+				prevK = k - 1
+			}
+			prevX := get(prevK)
+			prevY := prevX - prevK
+			for x > prevX && y > prevY {
+				middle = append(middle, &DiffLine{Op: " ", Text: a[x-1]})
+				x, y = x-1, y-1
+			}
+			if x == prevX {
+				middle = append(middle, &DiffLine{Op: "+", Text: b[y-1]})
+			} else {
+				middle = append(middle, &DiffLine{Op: "-", Text: a[x-1]})
+			}
+			x, y = prevX, prevY
+		}
+		for x > 0 && y > 0 {
+			middle = append(middle, &DiffLine{Op: " ", Text: a[x-1]})
+			x, y = x-1, y-1
+		}
+		for i, j := 0, len(middle)-1; i < j; i, j = i+1, j-1 {
+			middle[i], middle[j] = middle[j], middle[i]
+		}
+	}
+
+	return append(append(prefix, middle...), suffix...)
+}
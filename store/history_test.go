@@ -0,0 +1,125 @@
+package store
+
+import (
+	"strings"
+	"testing"
+)
+
+func splitLines(s string) []string {
+	if s == "" {
+		return nil
+	}
+	return strings.Split(s, "\n")
+}
+
+func formatDiffLines(lines []*DiffLine) string {
+	var parts []string
+	for _, l := range lines {
+		parts = append(parts, l.String())
+	}
+	return strings.Join(parts, "\n")
+}
+
+func TestDiffLines(t *testing.T) {
+	for _, tt := range []struct {
+		name string
+		a, b string
+		want string
+	}{
+		{
+			name: "empty",
+		},
+		{
+			name: "identical",
+			a:    "a\nb\nc",
+			b:    "a\nb\nc",
+			want: "  a\n  b\n  c",
+		},
+		{
+			name: "insert into empty",
+			b:    "a\nb",
+			want: "+ a\n+ b",
+		},
+		{
+			name: "insert",
+			a:    "a\nd",
+			b:    "a\nb\nc\nd",
+			want: "  a\n+ b\n+ c\n  d",
+		},
+		{
+			name: "delete all",
+			a:    "a\nb",
+			want: "- a\n- b",
+		},
+		{
+			name: "delete",
+			a:    "a\nb\nc\nd",
+			b:    "a\nd",
+			want: "  a\n- b\n- c\n  d",
+		},
+		{
+			name: "interleaved",
+			a:    "a\nb\nc\nd\ne",
+			b:    "a\nx\nc\ne\ny",
+			want: "  a\n- b\n+ x\n  c\n- d\n  e\n+ y",
+		},
+		{
+			name: "replace all",
+			a:    "a\nb",
+			b:    "c\nd",
+			want: "- a\n- b\n+ c\n+ d",
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			got := formatDiffLines(diffLines(splitLines(tt.a), splitLines(tt.b)))
+			if got != tt.want {
+				t.Errorf("diffLines(%q, %q) =\n%s\nwant\n%s", tt.a, tt.b, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDiffLinesEditScript(t *testing.T) {
+	// Applying the edit script to a must give b, and the number of edits
+	// must be minimal.
+	for _, tt := range []struct {
+		a, b  string
+		edits int
+	}{
+		{"a\nb\nc\na\nb\nb\na", "c\nb\na\nb\na\nc", 5},
+		{"x\ny\nz", "z\ny\nx", 4},
+		{"1\n2\n3\n4\n5\n6", "1\n3\n4\n6\n7", 3},
+	} {
+		lines := diffLines(splitLines(tt.a), splitLines(tt.b))
+		var a, b []string
+		edits := 0
+		for _, l := range lines {
+			switch l.Op {
+			case " ":
+				a = append(a, l.Text)
+				b = append(b, l.Text)
+			case "-":
+				a = append(a, l.Text)
+				edits++
+			case "+":
+				b = append(b, l.Text)
+				edits++
+			}
+		}
+		if strings.Join(a, "\n") != tt.a || strings.Join(b, "\n") != tt.b {
+			t.Errorf("diffLines(%q, %q) does not reproduce the inputs:\n%s", tt.a, tt.b, formatDiffLines(lines))
+		}
+		if edits != tt.edits {
+			t.Errorf("diffLines(%q, %q) has %d edits, want %d", tt.a, tt.b, edits, tt.edits)
+		}
+	}
+}
+
+func TestDiff(t *testing.T) {
+	a := "1\n2\n3\n4\n5\n6\n7\n8\n9\n10\n11\n12"
+	b := "1\nx\n3\n4\n5\n6\n7\n8\n9\n10\n11\ny"
+	want := "  1\n- 2\n+ x\n  3\n  4\n  5\n@ ...\n  9\n  10\n  11\n- 12\n+ y"
+	if got := formatDiffLines(Diff(a, b)); got != want {
+		t.Errorf("Diff =\n%s\nwant\n%s", got, want)
+	}
+}
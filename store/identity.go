@@ -0,0 +1,106 @@
+package store
+
+import (
+	"context"
+
+	"cloud.google.com/go/datastore"
+	"github.com/seaptc/seaptc/conference"
+	"github.com/seaptc/seaptc/log"
+)
+
+// migrateRenamedParticipants finds participants in the previously stored
+// participants that were renamed in the new import and moves the login code,
+// instructor classes, print signature, staff override and evaluation from the
+// previous ID to the new ID. Redirects from the previous IDs are added to
+// redirects.
+func (s *Store) migrateRenamedParticipants(ctx context.Context, tx *datastore.Transaction, version int64,
+	previous, participants []*conference.Participant, loginCodes map[string]string, redirects map[string]string) error {
+
+	matches := conference.MatchRenamedParticipants(previous, participants)
+	if len(matches) == 0 {
+		return nil
+	}
+
+	keys := []*datastore.Key{instructorClassesKey, printSignaturesKey, participantOverridesKey}
+	blobs := make([]blobEntity, len(keys))
+	err := noEntityOK(tx.GetMulti(keys, blobs))
+	if err != nil {
+		return err
+	}
+
+	instructorClasses := make(map[string][]int)
+	err = decodeBlob(instructorClassesKey.Name, blobs[0].Data, &instructorClasses)
+	if err != nil {
+		return err
+	}
+
+	printSignatures := make(map[string]string)
+	err = decodeBlob(printSignaturesKey.Name, blobs[1].Data, &printSignatures)
+	if err != nil {
+		return err
+	}
+
+	overrides, err := decodeParticipantOverrides(blobs[2].Data)
+	if err != nil {
+		return err
+	}
+	overridesChanged := false
+
+	for oldID, newID := range matches {
+		log.Logf(ctx, log.Notice, "Participant %s renamed to %s", oldID, newID)
+
+		if code, ok := loginCodes[oldID]; ok && loginCodes[newID] == "" {
+			loginCodes[newID] = code
+		}
+		if classNumbers, ok := instructorClasses[oldID]; ok {
+			instructorClasses[newID] = classNumbers
+			delete(instructorClasses, oldID)
+		}
+		if sig, ok := printSignatures[oldID]; ok {
+			printSignatures[newID] = sig
+			delete(printSignatures, oldID)
+		}
+		if moveParticipantOverride(overrides, oldID, newID) {
+			overridesChanged = true
+		}
+		if err := mergeEvaluations(tx, newID, oldID); err != nil {
+			return err
+		}
+
+		for from, to := range redirects {
+			if to == oldID {
+				redirects[from] = newID
+			}
+		}
+		redirects[oldID] = newID
+		delete(redirects, newID)
+	}
+
+	// The participants blob is written by the caller.
+	data, err := encodeBlob(instructorClassesKey.Name, instructorClasses)
+	if err != nil {
+		return err
+	}
+	if err := s.putVersionedBlob(ctx, tx, instructorClassesKey, version, data); err != nil {
+		return err
+	}
+
+	data, err = encodeBlob(printSignaturesKey.Name, printSignatures)
+	if err != nil {
+		return err
+	}
+	if _, err := tx.Put(printSignaturesKey, &blobEntity{Data: data}); err != nil {
+		return err
+	}
+
+	if overridesChanged {
+		data, err = encodeBlob(participantOverridesKey.Name, overrides)
+		if err != nil {
+			return err
+		}
+		if err := s.putVersionedBlob(ctx, tx, participantOverridesKey, version, data); err != nil {
+			return err
+		}
+	}
+	return nil
+}
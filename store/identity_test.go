@@ -0,0 +1,73 @@
+package store
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/seaptc/seaptc/conference"
+)
+
+// newTestStore returns a store backed by the Datastore emulator. Each store
+// uses a new project so that tests start with an empty datastore.
+func newTestStore(t *testing.T) *Store {
+	if os.Getenv("DATASTORE_EMULATOR_HOST") == "" {
+		t.Skip("DATASTORE_EMULATOR_HOST not set")
+	}
+	s, err := New(context.Background(), fmt.Sprintf("seaptc-test-%d", time.Now().UnixNano()), true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+func TestRenameKeepsLoginCodeAndEvaluation(t *testing.T) {
+	ctx := context.Background()
+	s := newTestStore(t)
+
+	before := &conference.Participant{ID: "jon", FirstName: "Jon", LastName: "Lee", RegistrationNumber: "r1"}
+	if err := s.PutParticipants(ctx, []*conference.Participant{before}); err != nil {
+		t.Fatal(err)
+	}
+	conf, _, err := s.GetConference(ctx, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	loginCode := conf.Participant("jon").LoginCode
+	if loginCode == "" {
+		t.Fatal("login code not assigned")
+	}
+	eval := &conference.Evaluation{Sessions: []*conference.SessionEvaluation{{Session: 1, ClassNumber: 101}}}
+	if err := s.SetEvaluation(ctx, "jon", eval); err != nil {
+		t.Fatal(err)
+	}
+
+	after := &conference.Participant{ID: "john", FirstName: "John", LastName: "Lee", RegistrationNumber: "r1"}
+	if err := s.PutParticipants(ctx, []*conference.Participant{after}); err != nil {
+		t.Fatal(err)
+	}
+	conf, _, err = s.GetConference(ctx, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := conf.Participant("john")
+	if p == nil {
+		t.Fatal("renamed participant not found")
+	}
+	if p.LoginCode != loginCode {
+		t.Errorf("login code = %q, want %q", p.LoginCode, loginCode)
+	}
+	if p := conf.Participant("jon"); p == nil || p.ID != "john" {
+		t.Errorf("previous ID does not redirect to the renamed participant")
+	}
+
+	eval, err = s.GetEvaluation(ctx, "john")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(eval.Sessions) != 1 || eval.Sessions[0].ClassNumber != 101 {
+		t.Errorf("evaluation sessions = %v, want class 101 in session 1", eval.Sessions)
+	}
+}
@@ -0,0 +1,211 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"cloud.google.com/go/datastore"
+	"github.com/seaptc/seaptc/conference"
+)
+
+// The participantRedirects blob maps an old participant ID to the ID of the
+// participant that replaced it.
+
+func decodeRedirects(data []byte) (map[string]string, error) {
+	redirects := make(map[string]string)
+	err := decodeBlob(redirectsKey.Name, data, &redirects)
+	if err != nil {
+		return nil, err
+	}
+	return redirects, nil
+}
+
+func updateParticipantRedirects(conf *conference.Conference, data []byte) (*conference.Conference, error) {
+	redirects, err := decodeRedirects(data)
+	if err != nil {
+		return nil, err
+	}
+	return conf.UpdateParticipantRedirects(redirects), nil
+}
+
+// dropMergedParticipants returns the participants that are not redirected to
+// another participant in the slice.
+func dropMergedParticipants(participants []*conference.Participant, redirects map[string]string) []*conference.Participant {
+	ids := make(map[string]bool, len(participants))
+	for _, p := range participants {
+		ids[p.ID] = true
+	}
+	result := make([]*conference.Participant, 0, len(participants))
+	for _, p := range participants {
+		if id, ok := redirects[p.ID]; ok && ids[id] {
+			continue
+		}
+		result = append(result, p)
+	}
+	return result
+}
+
+// MergeParticipants merges the duplicate participant into the survivor. The
+// survivor keeps its login code. Instructor classes and evaluations from the
+// duplicate are copied to the survivor where the survivor does not have
+// a value. The duplicate's staff override is moved to the survivor if the
+// survivor does not have an override. Future imports of the duplicate are
+// dropped.
+func (s *Store) MergeParticipants(ctx context.Context, survivorID, duplicateID string) error {
+	if survivorID == "" || duplicateID == "" || survivorID == duplicateID {
+		return errors.New("store: bad participant IDs for merge")
+	}
+
+	var version int64
+	_, err := s.client.RunInTransaction(ctx, func(tx *datastore.Transaction) error {
+		var m metaEntity
+		err := noEntityOK(tx.Get(metaKey, &m))
+		if err != nil {
+			return err
+		}
+
+		keys := []*datastore.Key{instructorClassesKey, redirectsKey, participantOverridesKey}
+		blobs := make([]blobEntity, len(keys))
+		err = noEntityOK(tx.GetMulti(keys, blobs))
+		if err != nil {
+			return err
+		}
+
+		stored, err := getParticipants(tx)
+		if err != nil {
+			return err
+		}
+		participants := stored.chunks.all()
+		found := false
+		i := 0
+		for _, p := range participants {
+			switch p.ID {
+			case duplicateID:
+				continue
+			case survivorID:
+				found = true
+			}
+			participants[i] = p
+			i++
+		}
+		participants = participants[:i]
+		if !found {
+			return fmt.Errorf("store: participant %s not found", survivorID)
+		}
+
+		instructorClasses := make(map[string][]int)
+		err = decodeBlob(instructorClassesKey.Name, blobs[0].Data, &instructorClasses)
+		if err != nil {
+			return err
+		}
+		if dup := instructorClasses[duplicateID]; dup != nil {
+			classNumbers := instructorClasses[survivorID]
+			if classNumbers == nil {
+				classNumbers = make([]int, conference.NumSession)
+			}
+			for i, n := range dup {
+				if i < len(classNumbers) && classNumbers[i] == 0 {
+					classNumbers[i] = n
+				}
+			}
+			instructorClasses[survivorID] = classNumbers
+			delete(instructorClasses, duplicateID)
+		}
+
+		redirects, err := decodeRedirects(blobs[1].Data)
+		if err != nil {
+			return err
+		}
+		for from, to := range redirects {
+			if to == duplicateID {
+				redirects[from] = survivorID
+			}
+		}
+		redirects[duplicateID] = survivorID
+		delete(redirects, survivorID)
+
+		overrides, err := decodeParticipantOverrides(blobs[2].Data)
+		if err != nil {
+			return err
+		}
+		moveParticipantOverride(overrides, duplicateID, survivorID)
+
+		if err := mergeEvaluations(tx, survivorID, duplicateID); err != nil {
+			return err
+		}
+
+		m.Version += 1
+		version = m.Version
+		if err := s.putParticipants(ctx, tx, m.Version, stored, participants); err != nil {
+			return err
+		}
+		values := []interface{}{instructorClasses, redirects, overrides}
+		for i, v := range values {
+			data, err := encodeBlob(keys[i].Name, v)
+			if err != nil {
+				return err
+			}
+			if err := s.putVersionedBlob(ctx, tx, keys[i], m.Version, data); err != nil {
+				return err
+			}
+		}
+		_, err = tx.Put(metaKey, &m)
+		if err != nil {
+			return err
+		}
+		return putAudit(ctx, tx, "mergeParticipants", survivorID, "merged "+duplicateID)
+	})
+	if err == nil {
+		s.publish(ctx, version)
+	}
+	return err
+}
+
+// mergeEvaluations copies the duplicate's evaluations to the survivor where
+// the survivor does not have an evaluation and deletes the duplicate's
+// evaluations.
+func mergeEvaluations(tx *datastore.Transaction, survivorID, duplicateID string) error {
+	keys := []*datastore.Key{evaluationKey(survivorID), evaluationKey(duplicateID)}
+	blobs := make([]blobEntity, len(keys))
+	err := noEntityOK(tx.GetMulti(keys, blobs))
+	if err != nil {
+		return err
+	}
+	if len(blobs[1].Data) == 0 {
+		return nil
+	}
+
+	evals := make([]conference.Evaluation, len(keys))
+	for i, b := range blobs {
+		if err := decodeBlob(evalKind, b.Data, &evals[i]); err != nil {
+			return err
+		}
+	}
+
+	survivor, dup := &evals[0], &evals[1]
+	if survivor.Conference == nil {
+		survivor.Conference = dup.Conference
+	}
+	if survivor.Note == nil {
+		survivor.Note = dup.Note
+	}
+	have := make(map[int]bool)
+	for _, se := range survivor.Sessions {
+		have[se.Session] = true
+	}
+	for _, se := range dup.Sessions {
+		if !have[se.Session] {
+			survivor.Sessions = append(survivor.Sessions, se)
+		}
+	}
+
+	data, err := encodeBlob(evalKind, survivor)
+	if err != nil {
+		return err
+	}
+	if _, err := tx.Put(keys[0], &blobEntity{Data: data}); err != nil {
+		return err
+	}
+	return tx.Delete(keys[1])
+}
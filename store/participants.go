@@ -0,0 +1,431 @@
+package store
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"hash/fnv"
+	"strconv"
+	"strings"
+
+	"cloud.google.com/go/datastore"
+	"github.com/seaptc/seaptc/conference"
+)
+
+// Participants are stored in numParticipantChunks blobs named
+// "participants.{n}" where n is a hex digit selected by a hash of the
+// participant ID. A write stores only the chunks that changed. Each chunk has
+// its own version, so instances load only the changed chunks, and its own
+// history.
+//
+// Before chunks were introduced, all participants were stored in the
+// "participants" blob. The legacy blob is read until the next write, which
+// replaces it with chunks.
+//
+// Staff overrides are stored in the participantOverrides blob, a map from
+// participant ID to *conference.ParticipantOverride. Imports do not modify
+// the overrides. Overrides are applied when the conference is loaded.
+
+const numParticipantChunks = 16
+
+var participantOverridesKey = blobKey("participantOverrides")
+
+func participantChunk(id string) int {
+	h := fnv.New32a()
+	h.Write([]byte(id))
+	return int(h.Sum32() % numParticipantChunks)
+}
+
+func participantChunkKey(i int) *datastore.Key {
+	return blobKey(fmt.Sprintf("%s.%x", participantsKey.Name, i))
+}
+
+// participantChunkIndex returns the chunk index for the named blob. If the
+// blob is not a participant chunk, ok is false.
+func participantChunkIndex(name string) (i int, ok bool) {
+	s := strings.TrimPrefix(name, participantsKey.Name+".")
+	if s == name || len(s) != 1 {
+		return 0, false
+	}
+	n, err := strconv.ParseInt(s, 16, 0)
+	if err != nil {
+		return 0, false
+	}
+	return int(n), true
+}
+
+func participantChunkNames() []string {
+	names := make([]string, numParticipantChunks)
+	for i := range names {
+		names[i] = participantChunkKey(i).Name
+	}
+	return names
+}
+
+// participantChunks holds participants split by chunk. Participants in a
+// chunk are in import order.
+type participantChunks [numParticipantChunks][]*conference.Participant
+
+func splitParticipants(participants []*conference.Participant) *participantChunks {
+	var chunks participantChunks
+	for _, p := range participants {
+		i := participantChunk(p.ID)
+		chunks[i] = append(chunks[i], p)
+	}
+	return &chunks
+}
+
+func (chunks *participantChunks) all() []*conference.Participant {
+	var participants []*conference.Participant
+	for _, chunk := range chunks {
+		participants = append(participants, chunk...)
+	}
+	return participants
+}
+
+// storedParticipants is the participant data read in a transaction.
+type storedParticipants struct {
+	chunks participantChunks
+	data   [numParticipantChunks][]byte
+	legacy bool
+}
+
+func getParticipants(tx *datastore.Transaction) (*storedParticipants, error) {
+	keys := make([]*datastore.Key, numParticipantChunks+1)
+	for i := 0; i < numParticipantChunks; i++ {
+		keys[i] = participantChunkKey(i)
+	}
+	keys[numParticipantChunks] = participantsKey
+	blobs := make([]blobEntity, len(keys))
+	err := noEntityOK(tx.GetMulti(keys, blobs))
+	if err != nil {
+		return nil, err
+	}
+
+	var sp storedParticipants
+	if legacy := blobs[numParticipantChunks].Data; len(legacy) > 0 {
+		var participants []*conference.Participant
+		err := decodeBlob(participantsKey.Name, legacy, &participants)
+		if err != nil {
+			return nil, err
+		}
+		sp.chunks = *splitParticipants(participants)
+		sp.legacy = true
+		return &sp, nil
+	}
+	for i := 0; i < numParticipantChunks; i++ {
+		sp.data[i] = blobs[i].Data
+		err := decodeBlob(participantsKey.Name, blobs[i].Data, &sp.chunks[i])
+		if err != nil {
+			return nil, err
+		}
+	}
+	return &sp, nil
+}
+
+// putParticipants stores the chunks that differ from the stored chunks.
+func (s *Store) putParticipants(ctx context.Context, tx *datastore.Transaction, version int64,
+	sp *storedParticipants, participants []*conference.Participant) error {
+
+	chunks := splitParticipants(participants)
+	for i, chunk := range chunks {
+		if !sp.legacy && len(chunk) == 0 && len(sp.data[i]) == 0 {
+			continue
+		}
+		data, err := encodeBlob(participantsKey.Name, chunk)
+		if err != nil {
+			return err
+		}
+		if !sp.legacy && bytes.Equal(data, sp.data[i]) {
+			continue
+		}
+		err = s.putVersionedBlob(ctx, tx, participantChunkKey(i), version, data)
+		if err != nil {
+			return err
+		}
+	}
+	if sp.legacy {
+		return tx.Delete(participantsKey)
+	}
+	return nil
+}
+
+// updateParticipantBlob loads a participant chunk or the legacy participants
+// blob into s.participants. The caller holds s.mu and updates the conference
+// with the participants.
+func (s *Store) updateParticipantBlob(name string, data []byte) error {
+	var participants []*conference.Participant
+	err := decodeBlob(participantsKey.Name, data, &participants)
+	if err != nil {
+		return err
+	}
+	if i, ok := participantChunkIndex(name); ok {
+		s.participants[i] = participants
+	} else {
+		s.participants = *splitParticipants(participants)
+	}
+	return nil
+}
+
+// IsParticipantChunk returns true if name is the name of a participant chunk
+// blob.
+func IsParticipantChunk(name string) bool {
+	_, ok := participantChunkIndex(name)
+	return ok
+}
+
+func isParticipantBlob(name string) bool {
+	_, ok := participantChunkIndex(name)
+	return ok || name == participantsKey.Name
+}
+
+func (s *Store) PutParticipants(ctx context.Context, participants []*conference.Participant) error {
+	var version int64
+	_, err := s.client.RunInTransaction(ctx, func(tx *datastore.Transaction) error {
+		var m metaEntity
+		err := noEntityOK(tx.Get(metaKey, &m))
+		if err != nil {
+			return err
+		}
+		var loginCodesBlob blobEntity
+		err = noEntityOK(tx.Get(loginCodesKey, &loginCodesBlob))
+		if err != nil {
+			return err
+		}
+
+		var redirectsBlob blobEntity
+		err = noEntityOK(tx.Get(redirectsKey, &redirectsBlob))
+		if err != nil {
+			return err
+		}
+		redirects, err := decodeRedirects(redirectsBlob.Data)
+		if err != nil {
+			return err
+		}
+
+		stored, err := getParticipants(tx)
+		if err != nil {
+			return err
+		}
+		previous := stored.chunks.all()
+
+		// Drop participants merged into another participant in this import.
+		participants = dropMergedParticipants(participants, redirects)
+		numRedirects := len(redirects)
+
+		loginCodes := make(map[string]string)
+		err = decodeBlob(loginCodesKey.Name, loginCodesBlob.Data, &loginCodes)
+		if err != nil {
+			return err
+		}
+
+		m.Version += 1
+		version = m.Version
+
+		// Carry data from renamed participants to the new participant IDs.
+		err = s.migrateRenamedParticipants(ctx, tx, m.Version, previous, participants, loginCodes, redirects)
+		if err != nil {
+			return err
+		}
+
+		// To ensure that login codes do not change when a participant is
+		// deleted and added again, the login codes are stored in separate
+		// blob. Assigned codes are never removed from the blob.
+
+		err = assignLoginCodes(loginCodes, participants)
+		if err != nil {
+			return err
+		}
+
+		_, err = tx.Put(metaKey, &m)
+		if err != nil {
+			return err
+		}
+
+		redirectsData, err := encodeBlob(redirectsKey.Name, redirects)
+		if err != nil {
+			return err
+		}
+
+		err = s.putVersionedBlob(ctx, tx, redirectsKey, m.Version, redirectsData)
+		if err != nil {
+			return err
+		}
+
+		loginCodesData, err := encodeBlob(loginCodesKey.Name, loginCodes)
+		if err != nil {
+			return err
+		}
+
+		_, err = tx.Put(loginCodesKey, &blobEntity{Data: loginCodesData})
+		if err != nil {
+			return err
+		}
+
+		err = s.putParticipants(ctx, tx, m.Version, stored, participants)
+		if err != nil {
+			return err
+		}
+
+		summary := summarizeParticipants(previous, participants)
+		if n := len(redirects) - numRedirects; n > 0 {
+			summary += fmt.Sprintf(", %d renamed", n)
+		}
+		return putAudit(ctx, tx, "putParticipants", participantsKey.Name, summary)
+	})
+	if err == nil {
+		s.publish(ctx, version)
+	}
+	return err
+}
+
+func decodeParticipantOverrides(data []byte) (map[string]*conference.ParticipantOverride, error) {
+	overrides := make(map[string]*conference.ParticipantOverride)
+	err := decodeBlob(participantOverridesKey.Name, data, &overrides)
+	if err != nil {
+		return nil, err
+	}
+	return overrides, nil
+}
+
+func updateParticipantOverrides(conf *conference.Conference, data []byte) (*conference.Conference, error) {
+	overrides, err := decodeParticipantOverrides(data)
+	if err != nil {
+		return nil, err
+	}
+	return conf.UpdateParticipantOverrides(overrides), nil
+}
+
+// SetParticipantOverride sets the staff override for a participant. An empty
+// override removes the participant's override.
+func (s *Store) SetParticipantOverride(ctx context.Context, participantID string, override *conference.ParticipantOverride) error {
+	var version int64
+	_, err := s.client.RunInTransaction(ctx, func(tx *datastore.Transaction) error {
+		var m metaEntity
+		err := noEntityOK(tx.Get(metaKey, &m))
+		if err != nil {
+			return err
+		}
+		var blob blobEntity
+		err = noEntityOK(tx.Get(participantOverridesKey, &blob))
+		if err != nil {
+			return err
+		}
+		overrides, err := decodeParticipantOverrides(blob.Data)
+		if err != nil {
+			return err
+		}
+
+		if override.IsEmpty() {
+			delete(overrides, participantID)
+		} else {
+			overrides[participantID] = override
+		}
+
+		data, err := encodeBlob(participantOverridesKey.Name, overrides)
+		if err != nil {
+			return err
+		}
+
+		m.Version += 1
+		version = m.Version
+		err = s.putVersionedBlob(ctx, tx, participantOverridesKey, m.Version, data)
+		if err != nil {
+			return err
+		}
+		_, err = tx.Put(metaKey, &m)
+		if err != nil {
+			return err
+		}
+		return putAudit(ctx, tx, "setParticipantOverride", participantID, summarizeParticipantOverride(override))
+	})
+	if err == nil {
+		s.publish(ctx, version)
+	}
+	return err
+}
+
+// SetParticipantClasses sets the classes in the participant's override to
+// the classes returned by fn. The conference passed to fn has the
+// participants and overrides read in the transaction so that fn can check
+// class capacity against the current registrations. If fn returns an error,
+// the error is returned and the override is not changed. The other override
+// fields are not changed.
+func (s *Store) SetParticipantClasses(ctx context.Context, participantID string, fn func(*conference.Conference) ([]int, error)) error {
+	s.mu.RLock()
+	conf := s.conf
+	s.mu.RUnlock()
+
+	var version int64
+	_, err := s.client.RunInTransaction(ctx, func(tx *datastore.Transaction) error {
+		var m metaEntity
+		err := noEntityOK(tx.Get(metaKey, &m))
+		if err != nil {
+			return err
+		}
+		var blob blobEntity
+		err = noEntityOK(tx.Get(participantOverridesKey, &blob))
+		if err != nil {
+			return err
+		}
+		overrides, err := decodeParticipantOverrides(blob.Data)
+		if err != nil {
+			return err
+		}
+		sp, err := getParticipants(tx)
+		if err != nil {
+			return err
+		}
+
+		classes, err := fn(conf.UpdateParticipantOverrides(overrides).UpdateParticipants(sp.chunks.all()))
+		if err != nil {
+			return err
+		}
+
+		var o conference.ParticipantOverride
+		if previous := overrides[participantID]; previous != nil {
+			o = *previous
+		}
+		o.Classes = classes
+		if o.IsEmpty() {
+			delete(overrides, participantID)
+		} else {
+			overrides[participantID] = &o
+		}
+
+		data, err := encodeBlob(participantOverridesKey.Name, overrides)
+		if err != nil {
+			return err
+		}
+
+		m.Version += 1
+		version = m.Version
+		err = s.putVersionedBlob(ctx, tx, participantOverridesKey, m.Version, data)
+		if err != nil {
+			return err
+		}
+		_, err = tx.Put(metaKey, &m)
+		if err != nil {
+			return err
+		}
+		return putAudit(ctx, tx, "setParticipantClasses", participantID, summarizeClassNumbers(classes))
+	})
+	if err == nil {
+		s.publish(ctx, version)
+	}
+	return err
+}
+
+// moveParticipantOverride moves the override for oldID to newID if newID does
+// not have an override. It returns true if overrides is modified.
+func moveParticipantOverride(overrides map[string]*conference.ParticipantOverride, oldID, newID string) bool {
+	o, ok := overrides[oldID]
+	if !ok {
+		return false
+	}
+	if _, ok := overrides[newID]; !ok {
+		overrides[newID] = o
+	}
+	delete(overrides, oldID)
+	return true
+}
@@ -0,0 +1,84 @@
+package store
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"time"
+
+	"cloud.google.com/go/datastore"
+	"github.com/seaptc/seaptc/conference"
+)
+
+// Classes read from the planning sheet or an uploaded file are kept in the
+// store while staff review the validation report. The report posts back the
+// ID of the pending classes instead of the classes, so the committed classes
+// are the classes that staff reviewed. Pending classes are root entities,
+// not in the conference entity group.
+
+// pendingClassesMaxAge is the time after which pending classes are deleted.
+const pendingClassesMaxAge = 24 * time.Hour
+
+type pendingClassesEntity struct {
+	Data    []byte `datastore:",noindex"`
+	Created time.Time
+}
+
+func pendingClassesKey(id string) *datastore.Key {
+	return datastore.NameKey("pendingClasses", id, nil)
+}
+
+// PutPendingClasses stores classes for review and returns the ID of the
+// pending classes. Expired pending classes are deleted.
+func (s *Store) PutPendingClasses(ctx context.Context, classes []*conference.Class) (string, error) {
+	data, err := encodeBlob(classesKey.Name, classes)
+	if err != nil {
+		return "", err
+	}
+	var b [16]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		return "", err
+	}
+	id := hex.EncodeToString(b[:])
+	now := time.Now()
+	if _, err := s.client.Put(ctx, pendingClassesKey(id), &pendingClassesEntity{Data: data, Created: now}); err != nil {
+		return "", err
+	}
+
+	expired, err := s.client.GetAll(ctx, datastore.NewQuery("pendingClasses").Filter("Created <", now.Add(-pendingClassesMaxAge)).KeysOnly(), nil)
+	if err != nil {
+		return "", err
+	}
+	if err := s.client.DeleteMulti(ctx, expired); err != nil {
+		return "", err
+	}
+	return id, nil
+}
+
+// GetPendingClasses returns the classes stored with PutPendingClasses. Nil
+// is returned if the pending classes do not exist or have expired.
+func (s *Store) GetPendingClasses(ctx context.Context, id string) ([]*conference.Class, error) {
+	if id == "" {
+		return nil, nil
+	}
+	var e pendingClassesEntity
+	err := s.client.Get(ctx, pendingClassesKey(id), &e)
+	if err == datastore.ErrNoSuchEntity {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	if time.Since(e.Created) > pendingClassesMaxAge {
+		return nil, nil
+	}
+	var classes []*conference.Class
+	if err := decodeBlob(classesKey.Name, e.Data, &classes); err != nil {
+		return nil, err
+	}
+	return classes, nil
+}
+
+// DeletePendingClasses deletes the pending classes.
+func (s *Store) DeletePendingClasses(ctx context.Context, id string) error {
+	return noEntityOK(s.client.Delete(ctx, pendingClassesKey(id)))
+}
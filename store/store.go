@@ -1,10 +1,8 @@
 package store
 
 import (
-	"bytes"
 	"context"
 	"crypto/rand"
-	"encoding/gob"
 	"encoding/json"
 	"errors"
 	"fmt"
@@ -16,6 +14,7 @@ import (
 	"cloud.google.com/go/datastore"
 	"github.com/seaptc/seaptc/conference"
 	"github.com/seaptc/seaptc/log"
+	"github.com/seaptc/seaptc/notify"
 )
 
 func DefaultProjectID() string {
@@ -26,13 +25,18 @@ func DefaultProjectID() string {
 }
 
 type Store struct {
-	client *datastore.Client
+	client   *datastore.Client
+	notifier notify.Notifier
 
 	mu         sync.RWMutex
 	versions   map[string]int64
 	lastSync   time.Time
 	maxVersion int64
 	conf       *conference.Conference
+
+	// participants is the imported participants by chunk. See
+	// participants.go.
+	participants participantChunks
 }
 
 func New(ctx context.Context, projectID string, useEmulator bool) (*Store, error) {
@@ -69,13 +73,13 @@ func evaluationKey(participantID string) *datastore.Key {
 	return &datastore.Key{Kind: "eval", Name: participantID, Parent: conferenceEntityGroupKey}
 }
 
-// blobEntity stores Gob or JSON encoded data as []byte.
+// blobEntity stores encoded data as []byte. See encoding.go for the format.
 type blobEntity struct {
 	// Version is used to query modified blobs. Version is set from
 	// metaEntty.Version. Some blobs do not use Version (it's always zero).
 	Version int64
 
-	// Data is the Gob or JSON encoded data.
+	// Data is the encoded data.
 	Data []byte `datastore:",noindex"`
 }
 
@@ -92,13 +96,15 @@ var (
 	instructorClassesKey = blobKey("instructorClasses")
 	loginCodesKey        = blobKey("loginCodes")
 	printSignaturesKey   = blobKey("printSignatures")
+	redirectsKey         = blobKey("participantRedirects")
 )
 
 var blobUpdaters = map[string]func(*conference.Conference, []byte) (*conference.Conference, error){
-	configurationKey.Name:     updateConfiguration,
-	classesKey.Name:           updateClasses,
-	participantsKey.Name:      updateParticipants,
-	instructorClassesKey.Name: updateInstructorClasses,
+	configurationKey.Name:        updateConfiguration,
+	classesKey.Name:              updateClasses,
+	instructorClassesKey.Name:    updateInstructorClasses,
+	redirectsKey.Name:            updateParticipantRedirects,
+	participantOverridesKey.Name: updateParticipantOverrides,
 }
 
 const maxAge = time.Minute * 10
@@ -119,59 +125,160 @@ func (s *Store) GetConference(ctx context.Context, noCache bool) (conf *conferen
 }
 
 func (s *Store) update(ctx context.Context, version int64) (*conference.Conference, error) {
-	var blobs []blobEntity
-	keys, err := s.client.GetAll(ctx,
-		datastore.NewQuery("blob").Ancestor(conferenceEntityGroupKey).Filter("Version >", version),
-		&blobs)
+	// Get the meta version before querying the blobs so that all blobs
+	// with a version up to the meta version are in the query results.
+	var m metaEntity
+	if err := noEntityOK(s.client.Get(ctx, metaKey, &m)); err != nil {
+		return nil, err
+	}
+
+	query := func(version int64) ([]*datastore.Key, []blobEntity, error) {
+		var blobs []blobEntity
+		keys, err := s.client.GetAll(ctx,
+			datastore.NewQuery("blob").Ancestor(conferenceEntityGroupKey).Filter("Version >", version),
+			&blobs)
+		if err != nil {
+			return nil, nil, fmt.Errorf("error querying for blob updates: %w", err)
+		}
+		return keys, blobs, nil
+	}
+	keys, blobs, err := query(version)
 	if err != nil {
-		return nil, fmt.Errorf("error querying for blob updates: %w", err)
+		return nil, err
+	}
+
+	// A meta version newer than the newest blob means that a blob was
+	// deleted. Deleted blobs are not in the query results, so reload all
+	// blobs.
+	newest := version
+	for _, b := range blobs {
+		if b.Version > newest {
+			newest = b.Version
+		}
+	}
+	reload := version > 0 && m.Version > newest
+	if reload {
+		keys, blobs, err = query(0)
+		if err != nil {
+			return nil, err
+		}
 	}
 
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
+	participantsChanged := false
+	if reload {
+		log.Logf(ctx, log.Info, "Reloading all blobs for version %d", m.Version)
+		s.conf = conference.New()
+		s.versions = map[string]int64{}
+		s.participants = participantChunks{}
+		participantsChanged = true
+	}
 	for i, b := range blobs {
 		name := keys[i].Name
 		if b.Version <= s.versions[name] {
 			continue
 		}
-		fn := blobUpdaters[name]
-		if fn == nil {
-			return nil, fmt.Errorf("store: unknown blob name %q", name)
-		}
 
 		log.Logf(ctx, log.Info, "Loading blob %s", name)
 
-		conf, err := fn(s.conf, b.Data)
-		if err != nil {
-			return nil, err
+		if isParticipantBlob(name) {
+			if err := s.updateParticipantBlob(name, b.Data); err != nil {
+				return nil, err
+			}
+			participantsChanged = true
+		} else {
+			fn := blobUpdaters[name]
+			if fn == nil {
+				return nil, fmt.Errorf("store: unknown blob name %q", name)
+			}
+			conf, err := fn(s.conf, b.Data)
+			if err != nil {
+				return nil, err
+			}
+			s.conf = conf
 		}
 		s.versions[name] = b.Version
 		if b.Version > s.maxVersion {
 			s.maxVersion = b.Version
 		}
-		s.conf = conf
+	}
+	if participantsChanged {
+		s.conf = s.conf.UpdateParticipants(s.participants.all())
+	}
+	if m.Version > s.maxVersion {
+		s.maxVersion = m.Version
 	}
 
 	s.lastSync = time.Now()
 	return s.conf, nil
 }
 
-func (s *Store) putBlob(ctx context.Context, key *datastore.Key, data []byte) error {
+// SetNotifier sets the notifier used to announce writes to other instances.
+// SetNotifier must be called before the store is used.
+func (s *Store) SetNotifier(n notify.Notifier) {
+	s.notifier = n
+}
+
+// Subscribe refreshes the cached conference when another instance announces
+// a version newer than the cached conference. Polling with maxAge is kept as
+// a fallback for lost notifications. The subscription ends when ctx is done.
+func (s *Store) Subscribe(ctx context.Context) error {
+	if s.notifier == nil {
+		return errors.New("store: notifier not set")
+	}
+	return s.notifier.Subscribe(ctx, func(version int64) {
+		s.mu.RLock()
+		current := s.maxVersion
+		s.mu.RUnlock()
+		if version <= current {
+			return
+		}
+		if _, err := s.update(ctx, current); err != nil {
+			log.Logf(ctx, log.Error, "refresh for version %d: %v", version, err)
+		}
+	})
+}
+
+// publish announces a write with the given version.
+func (s *Store) publish(ctx context.Context, version int64) {
+	if s.notifier == nil {
+		return
+	}
+	if err := s.notifier.Publish(ctx, version); err != nil {
+		log.Logf(ctx, log.Error, "publish version %d: %v", version, err)
+	}
+}
+
+func (s *Store) putBlob(ctx context.Context, operation string, key *datastore.Key, data []byte) error {
+	var version int64
 	_, err := s.client.RunInTransaction(ctx, func(tx *datastore.Transaction) error {
 		var m metaEntity
 		err := noEntityOK(tx.Get(metaKey, &m))
 		if err != nil {
 			return err
 		}
+		var old blobEntity
+		err = noEntityOK(tx.Get(key, &old))
+		if err != nil {
+			return err
+		}
 		m.Version += 1
-		_, err = tx.Put(key, &blobEntity{Version: m.Version, Data: data})
+		version = m.Version
+		err = s.putVersionedBlob(ctx, tx, key, m.Version, data)
 		if err != nil {
 			return err
 		}
 		_, err = tx.Put(metaKey, &m)
-		return err
+		if err != nil {
+			return err
+		}
+		return putAudit(ctx, tx, operation, key.Name, summarizeBlob(key.Name, old.Data, data))
 	})
+	if err == nil {
+		s.publish(ctx, version)
+	}
 	return err
 }
 
@@ -189,104 +296,37 @@ func (s *Store) PutConfiguration(ctx context.Context, config *conference.Configu
 	if err != nil {
 		return err
 	}
-	return s.putBlob(ctx, configurationKey, data)
+	return s.putBlob(ctx, "putConfiguration", configurationKey, data)
 }
 
 func updateClasses(conf *conference.Conference, data []byte) (*conference.Conference, error) {
 	var classes []*conference.Class
-	err := gob.NewDecoder(bytes.NewReader(data)).Decode(&classes)
+	err := decodeBlob(classesKey.Name, data, &classes)
 	if err != nil {
-		return nil, fmt.Errorf("store.classes: %w", err)
+		return nil, err
 	}
 	return conf.UpdateClasses(classes), nil
 }
 
 func (s *Store) PutClasses(ctx context.Context, classes []*conference.Class) error {
-	var buf bytes.Buffer
-	err := gob.NewEncoder(&buf).Encode(classes)
+	data, err := encodeBlob(classesKey.Name, classes)
 	if err != nil {
 		return err
 	}
-	return s.putBlob(ctx, classesKey, buf.Bytes())
-}
-
-func updateParticipants(conf *conference.Conference, data []byte) (*conference.Conference, error) {
-	var participants []*conference.Participant
-	err := gob.NewDecoder(bytes.NewReader(data)).Decode(&participants)
-	if err != nil {
-		return nil, fmt.Errorf("store.participants: %w", err)
-	}
-	return conf.UpdateParticipants(participants), nil
-}
-
-func (s *Store) PutParticipants(ctx context.Context, participants []*conference.Participant) error {
-	_, err := s.client.RunInTransaction(ctx, func(tx *datastore.Transaction) error {
-		var m metaEntity
-		err := noEntityOK(tx.Get(metaKey, &m))
-		if err != nil {
-			return err
-		}
-		var loginCodesBlob blobEntity
-		err = noEntityOK(tx.Get(loginCodesKey, &loginCodesBlob))
-		if err != nil {
-			return err
-		}
-
-		var loginCodes map[string]string
-		if len(loginCodesBlob.Data) == 0 {
-			loginCodes = make(map[string]string)
-		} else {
-			err := gob.NewDecoder(bytes.NewReader(loginCodesBlob.Data)).Decode(&loginCodes)
-			if err != nil {
-				return err
-			}
-		}
-
-		// To ensure that login codes do not change when a participant is
-		// deleted and added again, the login codes are stored in separate
-		// blob. Assigned codes are never removed from the blob.
-
-		err = assignLoginCodes(loginCodes, participants)
-		if err != nil {
-			return err
-		}
-
-		m.Version += 1
-		_, err = tx.Put(metaKey, &m)
-
-		var loginCodesBuf bytes.Buffer
-		err = gob.NewEncoder(&loginCodesBuf).Encode(loginCodes)
-		if err != nil {
-			return err
-		}
-
-		_, err = tx.Put(loginCodesKey, &blobEntity{Data: loginCodesBuf.Bytes()})
-		if err != nil {
-			return err
-		}
-
-		var participantsBuf bytes.Buffer
-		err = gob.NewEncoder(&participantsBuf).Encode(participants)
-		if err != nil {
-			return err
-		}
-
-		_, err = tx.Put(participantsKey, &blobEntity{Version: m.Version, Data: participantsBuf.Bytes()})
-		return err
-	})
-	return err
+	return s.putBlob(ctx, "putClasses", classesKey, data)
 }
 
 func updateInstructorClasses(conf *conference.Conference, data []byte) (*conference.Conference, error) {
 	var instructorClasses map[string][]int
-	err := gob.NewDecoder(bytes.NewReader(data)).Decode(&instructorClasses)
+	err := decodeBlob(instructorClassesKey.Name, data, &instructorClasses)
 	if err != nil {
-		return nil, fmt.Errorf("store.instructorClasses: %w", err)
+		return nil, err
 	}
 	return conf.UpdateInstructorClasses(instructorClasses), nil
 }
 
 func (s *Store) ModifyInstructorClasses(ctx context.Context, participantID string, modifications map[int]int) error {
+	var version int64
 	_, err := s.client.RunInTransaction(ctx, func(tx *datastore.Transaction) error {
 		var m metaEntity
 		err := noEntityOK(tx.Get(metaKey, &m))
@@ -300,21 +340,19 @@ func (s *Store) ModifyInstructorClasses(ctx context.Context, participantID strin
 		}
 
 		m.Version += 1
+		version = m.Version
 
-		var instructorClasses map[string][]int
-		if len(blob.Data) == 0 {
-			instructorClasses = make(map[string][]int)
-		} else {
-			err := gob.NewDecoder(bytes.NewReader(blob.Data)).Decode(&instructorClasses)
-			if err != nil {
-				return err
-			}
+		instructorClasses := make(map[string][]int)
+		err = decodeBlob(instructorClassesKey.Name, blob.Data, &instructorClasses)
+		if err != nil {
+			return err
 		}
 
 		classNumbers := instructorClasses[participantID]
 		if classNumbers == nil {
 			classNumbers = make([]int, conference.NumSession)
 		}
+		previous := append([]int(nil), classNumbers...)
 
 		for i, n := range modifications {
 			classNumbers[i] = n
@@ -334,19 +372,24 @@ func (s *Store) ModifyInstructorClasses(ctx context.Context, participantID strin
 			instructorClasses[participantID] = classNumbers
 		}
 
-		var buf bytes.Buffer
-		err = gob.NewEncoder(&buf).Encode(instructorClasses)
+		data, err := encodeBlob(instructorClassesKey.Name, instructorClasses)
 		if err != nil {
 			return err
 		}
 
-		_, err = tx.Put(instructorClassesKey, &blobEntity{Version: m.Version, Data: buf.Bytes()})
+		err = s.putVersionedBlob(ctx, tx, instructorClassesKey, m.Version, data)
 		if err != nil {
 			return err
 		}
 		_, err = tx.Put(metaKey, &m)
-		return err
+		if err != nil {
+			return err
+		}
+		return putAudit(ctx, tx, "modifyInstructorClasses", participantID, summarizeInstructorClasses(previous, classNumbers))
 	})
+	if err == nil {
+		s.publish(ctx, version)
+	}
 	return err
 }
 
@@ -357,14 +400,10 @@ func (s *Store) GetPrintSignatures(ctx context.Context) (map[string]string, erro
 		return nil, err
 	}
 
-	var printSignatures map[string]string
-	if len(blob.Data) == 0 {
-		printSignatures = make(map[string]string)
-	} else {
-		err := gob.NewDecoder(bytes.NewReader(blob.Data)).Decode(&printSignatures)
-		if err != nil {
-			return nil, err
-		}
+	printSignatures := make(map[string]string)
+	err = decodeBlob(printSignaturesKey.Name, blob.Data, &printSignatures)
+	if err != nil {
+		return nil, err
 	}
 
 	return printSignatures, nil
@@ -378,32 +417,33 @@ func (s *Store) SetPrintSignatures(ctx context.Context, modifiedSignatures map[s
 			return err
 		}
 
-		var printSignatures map[string]string
-		if len(blob.Data) == 0 {
-			printSignatures = make(map[string]string)
-		} else {
-			err := gob.NewDecoder(bytes.NewReader(blob.Data)).Decode(&printSignatures)
-			if err != nil {
-				return err
-			}
+		printSignatures := make(map[string]string)
+		err = decodeBlob(printSignaturesKey.Name, blob.Data, &printSignatures)
+		if err != nil {
+			return err
 		}
 
+		var set, cleared []string
 		for id, sig := range modifiedSignatures {
 			if sig == "" {
 				delete(printSignatures, id)
+				cleared = append(cleared, id)
 			} else {
 				printSignatures[id] = sig
+				set = append(set, id)
 			}
 		}
 
-		var buf bytes.Buffer
-		err = gob.NewEncoder(&buf).Encode(printSignatures)
+		data, err := encodeBlob(printSignaturesKey.Name, printSignatures)
 		if err != nil {
 			return err
 		}
 
-		_, err = tx.Put(printSignaturesKey, &blobEntity{Data: buf.Bytes()})
-		return err
+		_, err = tx.Put(printSignaturesKey, &blobEntity{Data: data})
+		if err != nil {
+			return err
+		}
+		return putAudit(ctx, tx, "setPrintSignatures", printSignaturesKey.Name, diffSummary("signatures", set, cleared, nil))
 	})
 	return err
 }
@@ -415,14 +455,32 @@ func (s *Store) GetEvaluation(ctx context.Context, participantID string) (*confe
 		return nil, err
 	}
 	var eval conference.Evaluation
-	if len(blob.Data) > 0 {
-		err = gob.NewDecoder(bytes.NewReader(blob.Data)).Decode(&eval)
+	err = decodeBlob(evalKind, blob.Data, &eval)
+	if err != nil {
+		return nil, err
+	}
+	eval.ParticipantID = participantID
+	return &eval, nil
+}
+
+// GetEvaluations returns all evaluations by participant ID.
+func (s *Store) GetEvaluations(ctx context.Context) (map[string]*conference.Evaluation, error) {
+	var blobs []*blobEntity
+	keys, err := s.client.GetAll(ctx, datastore.NewQuery("eval").Ancestor(conferenceEntityGroupKey), &blobs)
+	if err != nil {
+		return nil, err
+	}
+	result := make(map[string]*conference.Evaluation, len(keys))
+	for i, key := range keys {
+		var eval conference.Evaluation
+		err := decodeBlob(evalKind, blobs[i].Data, &eval)
 		if err != nil {
-			return nil, fmt.Errorf("store.eval: error decoded gob: %w", err)
+			return nil, fmt.Errorf("%w (participant %s)", err, key.Name)
 		}
+		eval.ParticipantID = key.Name
+		result[key.Name] = &eval
 	}
-	eval.ParticipantID = participantID
-	return &eval, err
+	return result, nil
 }
 
 func (s *Store) SetEvaluation(ctx context.Context, participantID string, modifiedEval *conference.Evaluation) error {
@@ -437,11 +495,9 @@ func (s *Store) SetEvaluation(ctx context.Context, participantID string, modifie
 		}
 
 		var eval conference.Evaluation
-		if len(blob.Data) > 0 {
-			err = gob.NewDecoder(bytes.NewReader(blob.Data)).Decode(&eval)
-			if err != nil {
-				return err
-			}
+		err = decodeBlob(evalKind, blob.Data, &eval)
+		if err != nil {
+			return err
 		}
 
 		if modifiedEval.Conference != nil {
@@ -454,23 +510,54 @@ func (s *Store) SetEvaluation(ctx context.Context, participantID string, modifie
 			eval.SetSession(se)
 		}
 
-		var buf bytes.Buffer
-		err = gob.NewEncoder(&buf).Encode(&eval)
+		data, err := encodeBlob(evalKind, &eval)
 		if err != nil {
 			return err
 		}
 
-		_, err = tx.Put(key, &blobEntity{Data: buf.Bytes()})
-		return err
+		_, err = tx.Put(key, &blobEntity{Data: data})
+		if err != nil {
+			return err
+		}
+		return putAudit(ctx, tx, "setEvaluation", participantID, summarizeEvaluation(modifiedEval))
 	})
 	return err
 }
 
+// DeleteBlob deletes the named blob. The meta version is incremented so that
+// running instances reload their blobs without the deleted blob.
 func (s *Store) DeleteBlob(ctx context.Context, name string) error {
 	if name == "" {
 		return errors.New("store: empty blob name")
 	}
-	return noEntityOK(s.client.Delete(ctx, blobKey(name)))
+	var version int64
+	_, err := s.client.RunInTransaction(ctx, func(tx *datastore.Transaction) error {
+		var m metaEntity
+		err := noEntityOK(tx.Get(metaKey, &m))
+		if err != nil {
+			return err
+		}
+		var blob blobEntity
+		err = noEntityOK(tx.Get(blobKey(name), &blob))
+		if err != nil {
+			return err
+		}
+		err = noEntityOK(tx.Delete(blobKey(name)))
+		if err != nil {
+			return err
+		}
+		m.Version += 1
+		version = m.Version
+		_, err = tx.Put(metaKey, &m)
+		if err != nil {
+			return err
+		}
+		return putAudit(ctx, tx, "deleteBlob", name, fmt.Sprintf("deleted %d bytes", len(blob.Data)))
+	})
+	if err == nil {
+		s.publish(ctx, version)
+	}
+	return err
 }
 
 func noEntityOK(err error) error {
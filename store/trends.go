@@ -0,0 +1,139 @@
+package store
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+
+	"cloud.google.com/go/datastore"
+	"github.com/seaptc/seaptc/conference"
+)
+
+// Class summaries for previous conference years are stored in the conference
+// entity group with one classYears entity per year. The data is a
+// []*conference.ClassYear in an envelope with the classYears kind, see
+// encoding.go. The summaries are included in backups.
+
+type classYearsEntity struct {
+	Year int
+	Data []byte `datastore:",noindex"`
+}
+
+func classYearsKey(year int) *datastore.Key {
+	return datastore.NameKey(classYearsKind, strconv.Itoa(year), conferenceEntityGroupKey)
+}
+
+// GetClassYears returns the stored class summaries for all years sorted by
+// year and class number.
+func (s *Store) GetClassYears(ctx context.Context) ([]*conference.ClassYear, error) {
+	var entities []*classYearsEntity
+	_, err := s.client.GetAll(ctx, datastore.NewQuery(classYearsKind).Ancestor(conferenceEntityGroupKey), &entities)
+	if err != nil {
+		return nil, err
+	}
+	var result []*conference.ClassYear
+	for _, e := range entities {
+		var classYears []*conference.ClassYear
+		if err := decodeBlob(classYearsKind, e.Data, &classYears); err != nil {
+			return nil, fmt.Errorf("%w (year %d)", err, e.Year)
+		}
+		result = append(result, classYears...)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		if result[i].Year != result[j].Year {
+			return result[i].Year < result[j].Year
+		}
+		return result[i].Number < result[j].Number
+	})
+	return result, nil
+}
+
+// PutClassYears stores the class summaries for year, replacing previously
+// stored summaries for the year.
+func (s *Store) PutClassYears(ctx context.Context, year int, classYears []*conference.ClassYear) error {
+	for _, cy := range classYears {
+		if cy.Year != year {
+			return fmt.Errorf("store: class %d summary is for %d, not %d", cy.Number, cy.Year, year)
+		}
+	}
+	data, err := encodeBlob(classYearsKind, classYears)
+	if err != nil {
+		return err
+	}
+	_, err = s.client.RunInTransaction(ctx, func(tx *datastore.Transaction) error {
+		_, err := tx.Put(classYearsKey(year), &classYearsEntity{Year: year, Data: data})
+		if err != nil {
+			return err
+		}
+		return putAudit(ctx, tx, "putClassYears", strconv.Itoa(year), fmt.Sprintf("%d classes", len(classYears)))
+	})
+	return err
+}
+
+// DeleteClassYears deletes the class summaries for year.
+func (s *Store) DeleteClassYears(ctx context.Context, year int) error {
+	_, err := s.client.RunInTransaction(ctx, func(tx *datastore.Transaction) error {
+		if err := noEntityOK(tx.Delete(classYearsKey(year))); err != nil {
+			return err
+		}
+		return putAudit(ctx, tx, "deleteClassYears", strconv.Itoa(year), "deleted")
+	})
+	return err
+}
+
+// ConferenceFromBackup returns the conference and evaluations in a backup
+// archive written by Backup. The archive is not stored. Use this function to
+// summarize a previous conference year.
+func ConferenceFromBackup(r io.Reader) (*conference.Conference, map[string]*conference.Evaluation, error) {
+	archive, err := readBackup(r)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	conf := conference.New()
+	var participants []*conference.Participant
+	evals := make(map[string]*conference.Evaluation)
+	for _, item := range archive.Items {
+		schema := item.Schema
+		if schema == 0 {
+			schema = 1
+		}
+		switch item.Kind {
+		case "blob":
+			data, err := blobFromJSON(item.Name, schema, item.Data)
+			if err != nil {
+				return nil, nil, err
+			}
+			if isParticipantBlob(item.Name) {
+				var chunk []*conference.Participant
+				if err := decodeBlob(participantsKey.Name, data, &chunk); err != nil {
+					return nil, nil, err
+				}
+				participants = append(participants, chunk...)
+			} else if fn := blobUpdaters[item.Name]; fn != nil {
+				conf, err = fn(conf, data)
+				if err != nil {
+					return nil, nil, err
+				}
+			}
+		case classYearsKind:
+			// Summaries of other years are not part of the conference.
+		case evalKind:
+			data, err := blobFromJSON(evalKind, schema, item.Data)
+			if err != nil {
+				return nil, nil, fmt.Errorf("%w (participant %s)", err, item.Name)
+			}
+			var eval conference.Evaluation
+			if err := decodeBlob(evalKind, data, &eval); err != nil {
+				return nil, nil, fmt.Errorf("%w (participant %s)", err, item.Name)
+			}
+			eval.ParticipantID = item.Name
+			evals[item.Name] = &eval
+		default:
+			return nil, nil, fmt.Errorf("store: unknown backup item kind %q", item.Kind)
+		}
+	}
+	return conf.UpdateParticipants(participants), evals, nil
+}
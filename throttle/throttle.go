@@ -0,0 +1,251 @@
+// Package throttle limits guessing of login codes by tracking failed
+// attempts per client and delaying further attempts with exponential
+// backoff.
+package throttle
+
+import (
+	"context"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/seaptc/seaptc/log"
+)
+
+const (
+	// freeFailures is the number of failures allowed before attempts are
+	// delayed.
+	freeFailures = 5
+
+	// sharedFreeFailures is the number of failures allowed for keys with
+	// sharedPrefix. Many clients can share an IP address, for example all
+	// participants on the venue network, so the IP address key allows more
+	// failures than keys for a single client.
+	sharedFreeFailures = 100
+	sharedPrefix       = "ip:"
+
+	// baseDelay is the delay after the first failure past freeFailures. The
+	// delay doubles with each additional failure up to maxDelay.
+	baseDelay = 2 * time.Second
+	maxDelay  = time.Hour
+
+	// forgetAfter is the time after the last failure when the failure count
+	// is reset.
+	forgetAfter = 6 * time.Hour
+)
+
+// Record is the attempt history for a key. Keys identify a client, for
+// example "ip:203.0.113.7" or "cookie:{id}".
+type Record struct {
+	Key      string
+	Kind     string // kind of the last failed attempt, for example "login"
+	Failures int
+	Last     time.Time // time of the last failure
+	Until    time.Time // attempts are rejected until this time
+}
+
+// Suspicious returns true if the record has more failures than allowed
+// without delay.
+func (r *Record) Suspicious() bool {
+	return r.Failures > allowedFailures(r.Key)
+}
+
+// allowedFailures returns the number of failures allowed for key before
+// attempts are delayed.
+func allowedFailures(key string) int {
+	if strings.HasPrefix(key, sharedPrefix) {
+		return sharedFreeFailures
+	}
+	return freeFailures
+}
+
+// Backend stores attempt records.
+type Backend interface {
+	// GetAttempts returns the records for keys. Missing records are nil.
+	GetAttempts(ctx context.Context, keys []string) ([]*Record, error)
+
+	// PutAttempt stores the record.
+	PutAttempt(ctx context.Context, r *Record) error
+
+	// DeleteAttempt deletes the record for key.
+	DeleteAttempt(ctx context.Context, key string) error
+
+	// ListAttempts returns records with a failure after since.
+	ListAttempts(ctx context.Context, since time.Time) ([]*Record, error)
+}
+
+// Throttler tracks failed attempts.
+type Throttler struct {
+	backend Backend
+	now     func() time.Time
+}
+
+// New returns a Throttler that stores records in backend.
+func New(backend Backend) *Throttler {
+	return &Throttler{backend: backend, now: time.Now}
+}
+
+// Wait returns the time until an attempt is allowed for all of keys. Empty
+// keys are ignored.
+func (t *Throttler) Wait(ctx context.Context, keys ...string) (time.Duration, error) {
+	keys = nonEmpty(keys)
+	records, err := t.backend.GetAttempts(ctx, keys)
+	if err != nil {
+		return 0, err
+	}
+	now := t.now()
+	var wait time.Duration
+	for _, r := range records {
+		if r != nil && r.Until.Sub(now) > wait {
+			wait = r.Until.Sub(now)
+		}
+	}
+	return wait, nil
+}
+
+// Fail records a failed attempt of the given kind for keys and logs the
+// failure.
+func (t *Throttler) Fail(ctx context.Context, kind string, keys ...string) error {
+	keys = nonEmpty(keys)
+	records, err := t.backend.GetAttempts(ctx, keys)
+	if err != nil {
+		return err
+	}
+	now := t.now()
+	for i, r := range records {
+		if r == nil || now.Sub(r.Last) > forgetAfter {
+			r = &Record{Key: keys[i]}
+		}
+		r.Kind = kind
+		r.Failures++
+		r.Last = now
+		if n := r.Failures - allowedFailures(r.Key); n > 0 {
+			r.Until = now.Add(delay(n))
+		}
+		severity := log.Info
+		if r.Suspicious() {
+			severity = log.Warning
+		}
+		log.Logf(ctx, severity, "%s failed for %s, %d failures, blocked until %s",
+			kind, r.Key, r.Failures, r.Until.Format(time.RFC3339))
+		if err := t.backend.PutAttempt(ctx, r); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Reset clears the failures for keys. Empty keys are ignored.
+func (t *Throttler) Reset(ctx context.Context, keys ...string) error {
+	for _, key := range nonEmpty(keys) {
+		if err := t.backend.DeleteAttempt(ctx, key); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ResetClient clears the failures for keys that identify a single client.
+// Shared keys are kept and left to decay. Otherwise a client with one valid
+// code could reset the limit for its IP address after each run of failures.
+func (t *Throttler) ResetClient(ctx context.Context, keys ...string) error {
+	var clientKeys []string
+	for _, key := range keys {
+		if !strings.HasPrefix(key, sharedPrefix) {
+			clientKeys = append(clientKeys, key)
+		}
+	}
+	return t.Reset(ctx, clientKeys...)
+}
+
+// Recent returns the records with failures in the forget period, most
+// recent first.
+func (t *Throttler) Recent(ctx context.Context) ([]*Record, error) {
+	records, err := t.backend.ListAttempts(ctx, t.now().Add(-forgetAfter))
+	if err != nil {
+		return nil, err
+	}
+	sort.Slice(records, func(i, j int) bool { return records[i].Last.After(records[j].Last) })
+	return records, nil
+}
+
+// delay returns the delay after n failures past the allowed failures.
+func delay(n int) time.Duration {
+	d := baseDelay
+	for i := 1; i < n && d < maxDelay; i++ {
+		d *= 2
+	}
+	if d > maxDelay {
+		d = maxDelay
+	}
+	return d
+}
+
+func nonEmpty(keys []string) []string {
+	var result []string
+	for _, k := range keys {
+		if k != "" {
+			result = append(result, k)
+		}
+	}
+	return result
+}
+
+// Memory is a Backend for a single instance.
+type Memory struct {
+	mu      sync.Mutex
+	records map[string]*Record
+}
+
+func NewMemory() *Memory {
+	return &Memory{records: make(map[string]*Record)}
+}
+
+func (m *Memory) GetAttempts(ctx context.Context, keys []string) ([]*Record, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	result := make([]*Record, len(keys))
+	for i, k := range keys {
+		if r := m.records[k]; r != nil {
+			c := *r
+			result[i] = &c
+		}
+	}
+	return result, nil
+}
+
+func (m *Memory) PutAttempt(ctx context.Context, r *Record) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	c := *r
+	m.records[r.Key] = &c
+
+	// Forget old records.
+	for k, r := range m.records {
+		if time.Since(r.Last) > forgetAfter {
+			delete(m.records, k)
+		}
+	}
+	return nil
+}
+
+func (m *Memory) DeleteAttempt(ctx context.Context, key string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.records, key)
+	return nil
+}
+
+func (m *Memory) ListAttempts(ctx context.Context, since time.Time) ([]*Record, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var result []*Record
+	for _, r := range m.records {
+		if r.Last.After(since) {
+			c := *r
+			result = append(result, &c)
+		}
+	}
+	return result, nil
+}